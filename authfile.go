@@ -0,0 +1,167 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ParseAuthFile parses passwords in the format used by collectd's AuthFile
+// option. Each line contains a username and password separated by a colon.
+// Blank lines and lines starting with # are ignored.
+func ParseAuthFile(r io.Reader) (Passwords, error) {
+	p := Passwords{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		user, password, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		p[strings.TrimSpace(user)] = strings.TrimSpace(password)
+	}
+	return p, scanner.Err()
+}
+
+// An AuthFile is an AuthDB that reads passwords from a collectd AuthFile.
+// Like collectd, the file is reloaded when it is modified. It can also be
+// reloaded by calling Reload or by using ReloadOnSignal.
+type AuthFile struct {
+	path string
+
+	// loaded is replaced each time the file is read, so Password doesn't
+	// need a lock.
+	loaded atomic.Pointer[authFileContents]
+
+	// checked is when the file was last checked, in Unix nanoseconds.
+	checked atomic.Int64
+}
+
+// authFileContents are the passwords read from an AuthFile.
+type authFileContents struct {
+	passwords Passwords
+	modTime   time.Time
+}
+
+// authFileCheckInterval is how often an AuthFile checks whether it has been
+// modified.
+const authFileCheckInterval = time.Second
+
+// LoadAuthFile reads passwords from the collectd AuthFile at path.
+func LoadAuthFile(path string) (*AuthFile, error) {
+	f := &AuthFile{path: path}
+	err := f.Reload()
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload reads the passwords from the file again. If this fails the previous
+// passwords are kept.
+func (f *AuthFile) Reload() error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	passwords, err := ParseAuthFile(file)
+	if err != nil {
+		return err
+	}
+
+	f.loaded.Store(&authFileContents{passwords, info.ModTime()})
+	f.checked.Store(time.Now().UnixNano())
+	return nil
+}
+
+// Password returns the password for user, reloading the file first if it has
+// been modified.
+func (f *AuthFile) Password(user string) (string, bool) {
+	f.checkModified()
+
+	return f.loaded.Load().passwords.Password(user)
+}
+
+// checkModified reloads the file if it has changed since it was last read.
+func (f *AuthFile) checkModified() {
+	now := time.Now().UnixNano()
+	last := f.checked.Load()
+	if now-last < int64(authFileCheckInterval) {
+		return
+	}
+	// only one caller checks the file each interval
+	if !f.checked.CompareAndSwap(last, now) {
+		return
+	}
+
+	modTime := f.loaded.Load().modTime
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		log.Println("error: Failed to check auth file", err)
+		return
+	}
+	if info.ModTime().Equal(modTime) {
+		return
+	}
+	err = f.Reload()
+	if err != nil {
+		log.Println("error: Failed to reload auth file", err)
+	}
+}
+
+// ReloadOnSignal reloads the file whenever the process receives one of the
+// given signals, or SIGHUP if no signals are given. On platforms without
+// SIGHUP, such as Windows, it does nothing if no signals are given. Call the
+// returned function to stop.
+func (f *AuthFile) ReloadOnSignal(sig ...os.Signal) (stop func()) {
+	if len(sig) == 0 {
+		sig = reloadSignals
+	}
+	if len(sig) == 0 {
+		return func() {}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sig...)
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-c:
+				err := f.Reload()
+				if err != nil {
+					log.Println("error: Failed to reload auth file", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(c)
+			close(done)
+		})
+	}
+}
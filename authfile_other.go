@@ -0,0 +1,12 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+//go:build !unix
+
+package gocollectd
+
+import "os"
+
+// reloadSignals is empty on platforms without SIGHUP, so ReloadOnSignal only
+// watches the signals it is given.
+var reloadSignals []os.Signal
@@ -0,0 +1,101 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAuthFile(t *testing.T) {
+	in := strings.Join([]string{
+		"# a comment",
+		"admin: secret",
+		"",
+		"  spaces  :  more spaces  ",
+		"nopassword",
+		"colons: in:password",
+	}, "\n")
+	expected := Passwords{
+		"admin":  "secret",
+		"spaces": "more spaces",
+		"colons": "in:password",
+	}
+	result, err := ParseAuthFile(strings.NewReader(in))
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected\n%v\ngot\n%v", expected, result)
+	}
+}
+
+func TestAuthFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth_file")
+	err := os.WriteFile(path, []byte("admin: secret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := LoadAuthFile(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if password, ok := f.Password("admin"); !ok || password != "secret" {
+		t.Errorf("expected secret, got %v %v", password, ok)
+	}
+
+	// change the file and pretend it has not been checked for a while
+	err = os.WriteFile(path, []byte("admin: rotated\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Minute)
+	err = os.Chtimes(path, future, future)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.checked.Store(0)
+
+	if password, ok := f.Password("admin"); !ok || password != "rotated" {
+		t.Errorf("expected rotated, got %v %v", password, ok)
+	}
+
+	// a failed reload keeps the old passwords
+	os.Remove(path)
+	if f.Reload() == nil {
+		t.Errorf("expected an error reloading a missing file")
+	}
+	if password, ok := f.Password("admin"); !ok || password != "rotated" {
+		t.Errorf("expected rotated, got %v %v", password, ok)
+	}
+}
+
+func TestLoadAuthFileMissing(t *testing.T) {
+	_, err := LoadAuthFile(filepath.Join(t.TempDir(), "missing"))
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func BenchmarkAuthFilePassword(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "passwd")
+	err := os.WriteFile(path, []byte("admin: secret\n"), 0600)
+	if err != nil {
+		b.Fatal(err)
+	}
+	f, err := LoadAuthFile(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			f.Password("admin")
+		}
+	})
+}
@@ -0,0 +1,14 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+//go:build unix
+
+package gocollectd
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals are the signals ReloadOnSignal uses if none are given.
+var reloadSignals = []os.Signal{syscall.SIGHUP}
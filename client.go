@@ -0,0 +1,159 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"os"
+	"time"
+)
+
+// DefaultFlushInterval is how long a Client buffers values before sending
+// them, unless ClientOptions says otherwise.
+const DefaultFlushInterval = time.Second
+
+// ClientOptions configures a Client.
+type ClientOptions struct {
+	// SecurityLevel is used to sign or encrypt data with Username and
+	// Password, in the same way as collectd's SecurityLevel option.
+	SecurityLevel SecurityLevel
+	Username      string
+	Password      string
+
+	// MaxSize is the maximum size of each datagram. If it is zero
+	// DefaultPacketSize is used.
+	MaxSize int
+
+	// FlushInterval is the longest time values are buffered before they are
+	// sent. If it is zero DefaultFlushInterval is used.
+	FlushInterval time.Duration
+}
+
+// A Client sends values to a collectd server over UDP.
+type Client struct {
+	// Hostname is the host that values are sent from. Dial sets it to the
+	// local hostname.
+	Hostname string
+
+	// Interval is the interval sent with each value. Dial sets it to 10
+	// seconds, the same as collectd's default.
+	Interval time.Duration
+
+	conn net.Conn
+	w    *Writer
+}
+
+// Dial returns a Client that sends data to the collectd server at addr, such
+// as "collectd.example.com:25826". addr can be a unicast or multicast address,
+// using IPv4 or IPv6. If opts is nil, data is sent unsigned and unencrypted.
+func Dial(addr string, opts *ClientOptions) (*Client, error) {
+	if opts == nil {
+		opts = &ClientOptions{}
+	}
+	if opts.SecurityLevel > SecurityNone && opts.Username == "" {
+		return nil, ErrorNoUsername
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	w := NewWriter(conn)
+	if opts.MaxSize > 0 {
+		w.MaxSize = opts.MaxSize
+	}
+	w.FlushInterval = opts.FlushInterval
+	if w.FlushInterval == 0 {
+		w.FlushInterval = DefaultFlushInterval
+	}
+	w.SecurityLevel = opts.SecurityLevel
+	w.Username = opts.Username
+	w.Password = opts.Password
+
+	c := &Client{
+		Hostname: hostname,
+		Interval: 10 * time.Second,
+		conn:     conn,
+		w:        w,
+	}
+	return c, nil
+}
+
+// Gauge sends gauge values, timestamped with the current time.
+func (c *Client) Gauge(plugin, pluginInstance, typ, typeInstance string, values ...float64) error {
+	b := make([]byte, 0, len(values)*8)
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint64(b, math.Float64bits(v))
+	}
+	return c.write(plugin, pluginInstance, typ, typeInstance, TypeGauge, b)
+}
+
+// Derive sends derive values, timestamped with the current time.
+func (c *Client) Derive(plugin, pluginInstance, typ, typeInstance string, values ...int64) error {
+	b := make([]byte, 0, len(values)*8)
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint64(b, uint64(v))
+	}
+	return c.write(plugin, pluginInstance, typ, typeInstance, TypeDerive, b)
+}
+
+// Counter sends counter values, timestamped with the current time.
+func (c *Client) Counter(plugin, pluginInstance, typ, typeInstance string, values ...uint64) error {
+	b := make([]byte, 0, len(values)*8)
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint64(b, v)
+	}
+	return c.write(plugin, pluginInstance, typ, typeInstance, TypeCounter, b)
+}
+
+// write sends values that all have the same data type.
+func (c *Client) write(plugin, pluginInstance, typ, typeInstance string, dataType uint8, b []byte) error {
+	dataTypes := make([]uint8, len(b)/8)
+	for i := range dataTypes {
+		dataTypes[i] = dataType
+	}
+	return c.WritePacket(Packet{
+		Hostname:       c.Hostname,
+		Plugin:         plugin,
+		PluginInstance: pluginInstance,
+		Type:           typ,
+		TypeInstance:   typeInstance,
+		CdTime:         cdTime(time.Now()),
+		CdInterval:     cdDuration(c.Interval),
+		DataTypes:      dataTypes,
+		Bytes:          b,
+	})
+}
+
+// WritePacket sends a packet.
+func (c *Client) WritePacket(p Packet) error {
+	return c.w.WritePacket(p)
+}
+
+// WriteNotification sends a notification straight away.
+func (c *Client) WriteNotification(n Notification) error {
+	return c.w.WriteNotification(n)
+}
+
+// Flush sends any buffered values. If values sent in the background since the
+// last call to Flush failed, that error is returned.
+func (c *Client) Flush() error {
+	return c.w.Flush()
+}
+
+// Close sends any buffered values and closes the connection.
+func (c *Client) Close() error {
+	err := c.w.Flush()
+	closeErr := c.conn.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
@@ -0,0 +1,129 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// listenLocal returns a UDP connection listening on a random local port.
+func listenLocal(t *testing.T) *net.UDPConn {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readPackets reads one datagram from conn and parses it.
+func readPackets(t *testing.T, conn *net.UDPConn, ps *Parser) []Packet {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, DefaultPacketSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets, err := ps.Parse(buf[:n])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return *packets
+}
+
+func TestClient(t *testing.T) {
+	conn := listenLocal(t)
+	c, err := Dial(conn.LocalAddr().String(), nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer c.Close()
+	c.Hostname = "laptop.lan"
+
+	start := time.Now()
+	c.Gauge("load", "", "load", "", 1.13, 0.89, 0.60)
+	c.Derive("interface", "lo0", "if_octets", "", 8914827, 8914828)
+	c.Counter("swap", "", "swap_io", "in", 1)
+	err = c.Flush()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	packets := readPackets(t, conn, &Parser{})
+	if len(packets) != 3 {
+		t.Fatalf("expected 3 packets, got %d", len(packets))
+	}
+	expected := [][]Number{
+		{Gauge(1.13), Gauge(0.89), Gauge(0.60)},
+		{Derive(8914827), Derive(8914828)},
+		{Counter(1)},
+	}
+	for i, p := range packets {
+		if p.Hostname != "laptop.lan" {
+			t.Errorf("%d: expected laptop.lan, got %v", i, p.Hostname)
+		}
+		if p.Time().Before(start.Add(-time.Millisecond)) || p.Time().After(time.Now()) {
+			t.Errorf("%d: expected current time, got %v", i, p.Time())
+		}
+		if p.CdInterval != 10737418240 {
+			t.Errorf("%d: expected 10 second interval, got %v", i, p.CdInterval)
+		}
+		numbers, err := p.ValueNumbers()
+		if err != nil {
+			t.Errorf("%d: expected no error, got %v", i, err)
+		}
+		if !reflect.DeepEqual(numbers, expected[i]) {
+			t.Errorf("%d: expected\n%v\ngot\n%v", i, expected[i], numbers)
+		}
+	}
+}
+
+func TestClientSecurity(t *testing.T) {
+	for _, level := range []SecurityLevel{SecuritySign, SecurityEncrypt} {
+		conn := listenLocal(t)
+		c, err := Dial(conn.LocalAddr().String(), &ClientOptions{
+			SecurityLevel: level,
+			Username:      "admin",
+			Password:      "secret",
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		c.Gauge("load", "", "load", "", 1.13, 0.89, 0.60)
+		c.Close()
+
+		packets := readPackets(t, conn, &Parser{AuthDB: Passwords{"admin": "secret"}})
+		if len(packets) != 1 {
+			t.Fatalf("%v: expected 1 packet, got %d", level, len(packets))
+		}
+		if packets[0].Security != level {
+			t.Errorf("expected %v, got %v", level, packets[0].Security)
+		}
+	}
+}
+
+func TestClientFlushInterval(t *testing.T) {
+	conn := listenLocal(t)
+	c, err := Dial(conn.LocalAddr().String(), &ClientOptions{FlushInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer c.Close()
+
+	c.Gauge("load", "", "load", "", 1.13, 0.89, 0.60)
+	packets := readPackets(t, conn, &Parser{})
+	if len(packets) != 1 {
+		t.Fatalf("expected 1 packet, got %d", len(packets))
+	}
+}
+
+func TestDialRequiresUsername(t *testing.T) {
+	_, err := Dial("127.0.0.1:25826", &ClientOptions{SecurityLevel: SecuritySign})
+	if err != ErrorNoUsername {
+		t.Errorf("expected '%v', got '%v'", ErrorNoUsername, err)
+	}
+}
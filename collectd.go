@@ -9,7 +9,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"strconv"
+	"strings"
 	"time"
 )
 
@@ -111,6 +111,7 @@ type Packet struct {
 	CdInterval     uint64
 	DataTypes      []uint8
 	Bytes          []byte
+	Security       SecurityLevel
 }
 
 // TimeUnixNano returns the measurement time in nanoseconds since unix epoch.
@@ -129,6 +130,46 @@ func (p Packet) Time() time.Time {
 	return time.Unix(0, p.TimeUnixNano())
 }
 
+// cdTime converts a go time into collectd's time format, which counts 2^-30
+// second intervals since unix epoch.
+func cdTime(t time.Time) uint64 {
+	return cdDuration(time.Duration(t.UnixNano()))
+}
+
+// cdDuration converts a go duration into collectd's time format.
+func cdDuration(d time.Duration) uint64 {
+	ns := uint64(d)
+	return (ns/1e9)<<30 | ((ns%1e9)<<30+5e8)/1e9
+}
+
+// Identifier returns the name collectd uses for this packet's values, in the
+// form host/plugin-instance/type-instance. Empty instances are left out.
+func (p Packet) Identifier() string {
+	s := p.Hostname + "/" + p.Plugin
+	if p.PluginInstance != "" {
+		s += "-" + p.PluginInstance
+	}
+	s += "/" + p.Type
+	if p.TypeInstance != "" {
+		s += "-" + p.TypeInstance
+	}
+	return s
+}
+
+// ParseIdentifier returns a packet with the host, plugin, type and instances
+// of an identifier in the form returned by Identifier. Like collectd, the
+// instances start after the first dash.
+func ParseIdentifier(s string) (Packet, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return Packet{}, fmt.Errorf("%q is not a collectd identifier", s)
+	}
+	p := Packet{Hostname: parts[0]}
+	p.Plugin, p.PluginInstance, _ = strings.Cut(parts[1], "-")
+	p.Type, p.TypeInstance, _ = strings.Cut(parts[2], "-")
+	return p, nil
+}
+
 // ValueCount returns the number of values in this packet.
 func (p Packet) ValueCount() int {
 	return len(p.DataTypes)
@@ -167,59 +208,17 @@ func (p Packet) ValueNumbers() ([]Number, error) {
 	return r, nil
 }
 
-// ValueNames attempts to reformat collectd's plugin/type/instance heirarchy
-// into a string for this packet.
-func (p Packet) Name() (name string) {
-	// todo: think of ways to make this not a compiled in hack
-	// todo: collectd 4 uses different patterns for some plugins
-	// https://collectd.org/wiki/index.php/V4_to_v5_migration_guide
-	switch p.Plugin {
-	case "df":
-		name = fmt.Sprintf("df_%s_%s", p.PluginInstance, p.TypeInstance)
-	case "interface":
-		name = fmt.Sprintf("%s_%s", p.Type, p.PluginInstance)
-	case "load":
-		name = "load"
-	case "memory":
-		name = fmt.Sprintf("memory_%s", p.TypeInstance)
-	default:
-		name = fmt.Sprintf("%s_%s_%s_%s", p.Plugin, p.PluginInstance, p.Type, p.TypeInstance)
-	}
-	return name
+// Name attempts to reformat collectd's plugin/type/instance heirarchy into a
+// string for this packet, using LegacyNamer. Use a Namer for other naming
+// schemes.
+func (p Packet) Name() string {
+	return LegacyNamer{}.Name(p)
 }
 
 // ValueNames attempts to reformat collectd's plugin/type/instance heirarchy
-// into a strings for each value in this packet.
+// into a strings for each value in this packet, using LegacyNamer.
 func (p Packet) ValueNames() []string {
-	r := make([]string, len(p.DataTypes))
-	for i := range p.DataTypes {
-		name := p.Name()
-		var valueName string
-		switch {
-		case p.Plugin == "df" && i == 0:
-			valueName = ""
-		case p.Plugin == "memory" && i == 0:
-			valueName = ""
-		case p.Plugin == "interface" && i == 0:
-			valueName = "tx"
-		case p.Plugin == "interface" && i == 1:
-			valueName = "rx"
-		case p.Plugin == "load" && i == 0:
-			valueName = "1"
-		case p.Plugin == "load" && i == 1:
-			valueName = "5"
-		case p.Plugin == "load" && i == 2:
-			valueName = "15"
-		default:
-			valueName = strconv.FormatInt(int64(i), 10)
-		}
-		if valueName == "" {
-			r[i] = name
-		} else {
-			r[i] = fmt.Sprintf("%s_%s", name, valueName)
-		}
-	}
-	return r
+	return LegacyNamer{}.ValueNames(p)
 }
 
 func byteReaderToNumber(dataType uint8, reader *bytes.Reader) (n Number, err error) {
@@ -9,7 +9,7 @@ import (
 	"time"
 )
 
-var testPacket = Packet{"laptop.lan", "fake", "", "", "", 1463827927039889790, 10737418240, []uint8{TypeDerive, TypeGauge, TypeDerive}, h2b("00 00 00 00 00 88 07 8b 41 cf 43 00 00 00 00 00 00 00 00 00 00 88 07 8c")}
+var testPacket = Packet{"laptop.lan", "fake", "", "", "", 1463827927039889790, 10737418240, []uint8{TypeDerive, TypeGauge, TypeDerive}, h2b("00 00 00 00 00 88 07 8b 41 cf 43 00 00 00 00 00 00 00 00 00 00 88 07 8c"), SecurityNone}
 var testDate = time.Date(2013, time.March, 14, 21, 19, 53, 804828672, time.UTC)
 var testValue = Value{TypeGauge, h2b("41 cf 43 00 00 00 00 00")}
 
@@ -106,23 +106,23 @@ func TestPacketName(t *testing.T) {
 		name   string
 	}{
 		{
-			Packet{"laptop.lan", "interface", "lo0", "if_octets", "", 1463827927249453056, 10737418240, []uint8{TypeDerive, TypeDerive}, []byte{}},
+			Packet{"laptop.lan", "interface", "lo0", "if_octets", "", 1463827927249453056, 10737418240, []uint8{TypeDerive, TypeDerive}, []byte{}, SecurityNone},
 			"if_octets_lo0",
 		},
 		{
-			Packet{"laptop.lan", "memory", "", "memory", "wired", 1463827927249453056, 10737418240, []uint8{TypeGauge}, []byte{}},
+			Packet{"laptop.lan", "memory", "", "memory", "wired", 1463827927249453056, 10737418240, []uint8{TypeGauge}, []byte{}, SecurityNone},
 			"memory_wired",
 		},
 		{
-			Packet{"laptop.lan", "load", "", "load", "wired", 1463827927249453056, 10737418240, []uint8{TypeGauge, TypeGauge, TypeGauge}, []byte{}},
+			Packet{"laptop.lan", "load", "", "load", "wired", 1463827927249453056, 10737418240, []uint8{TypeGauge, TypeGauge, TypeGauge}, []byte{}, SecurityNone},
 			"load",
 		},
 		{
-			Packet{"laptop.lan", "df", "root", "df_complex", "used", 1463827927249453056, 10737418240, []uint8{TypeGauge}, []byte{}},
+			Packet{"laptop.lan", "df", "root", "df_complex", "used", 1463827927249453056, 10737418240, []uint8{TypeGauge}, []byte{}, SecurityNone},
 			"df_root_used",
 		},
 		{
-			Packet{"laptop.lan", "plugin", "some", "thing", "here", 1463827927249453056, 10737418240, []uint8{TypeGauge, TypeGauge}, []byte{}},
+			Packet{"laptop.lan", "plugin", "some", "thing", "here", 1463827927249453056, 10737418240, []uint8{TypeGauge, TypeGauge}, []byte{}, SecurityNone},
 			"plugin_some_thing_here",
 		},
 	}
@@ -130,7 +130,7 @@ func TestPacketName(t *testing.T) {
 	for i, tst := range tests {
 		result := tst.packet.Name()
 		if tst.name != result {
-			t.Errorf("%i: expected\n%v\ngot\n%v", i, tst.name, result)
+			t.Errorf("%d: expected\n%v\ngot\n%v", i, tst.name, result)
 		}
 	}
 
@@ -142,23 +142,23 @@ func TestPacketValueNames(t *testing.T) {
 		names  []string
 	}{
 		{
-			Packet{"laptop.lan", "interface", "lo0", "if_octets", "", 1463827927249453056, 10737418240, []uint8{TypeDerive, TypeDerive}, []byte{}},
+			Packet{"laptop.lan", "interface", "lo0", "if_octets", "", 1463827927249453056, 10737418240, []uint8{TypeDerive, TypeDerive}, []byte{}, SecurityNone},
 			[]string{"if_octets_lo0_tx", "if_octets_lo0_rx"},
 		},
 		{
-			Packet{"laptop.lan", "memory", "", "memory", "wired", 1463827927249453056, 10737418240, []uint8{TypeGauge}, []byte{}},
+			Packet{"laptop.lan", "memory", "", "memory", "wired", 1463827927249453056, 10737418240, []uint8{TypeGauge}, []byte{}, SecurityNone},
 			[]string{"memory_wired"},
 		},
 		{
-			Packet{"laptop.lan", "load", "", "load", "wired", 1463827927249453056, 10737418240, []uint8{TypeGauge, TypeGauge, TypeGauge}, []byte{}},
+			Packet{"laptop.lan", "load", "", "load", "wired", 1463827927249453056, 10737418240, []uint8{TypeGauge, TypeGauge, TypeGauge}, []byte{}, SecurityNone},
 			[]string{"load_1", "load_5", "load_15"},
 		},
 		{
-			Packet{"laptop.lan", "df", "root", "df_complex", "used", 1463827927249453056, 10737418240, []uint8{TypeGauge}, []byte{}},
+			Packet{"laptop.lan", "df", "root", "df_complex", "used", 1463827927249453056, 10737418240, []uint8{TypeGauge}, []byte{}, SecurityNone},
 			[]string{"df_root_used"},
 		},
 		{
-			Packet{"laptop.lan", "plugin", "some", "thing", "here", 1463827927249453056, 10737418240, []uint8{TypeGauge, TypeGauge}, []byte{}},
+			Packet{"laptop.lan", "plugin", "some", "thing", "here", 1463827927249453056, 10737418240, []uint8{TypeGauge, TypeGauge}, []byte{}, SecurityNone},
 			[]string{"plugin_some_thing_here_0", "plugin_some_thing_here_1"},
 		},
 	}
@@ -166,8 +166,47 @@ func TestPacketValueNames(t *testing.T) {
 	for i, tst := range tests {
 		result := tst.packet.ValueNames()
 		if !reflect.DeepEqual(result, tst.names) {
-			t.Errorf("%i: expected\n%v\ngot\n%v", i, tst.names, result)
+			t.Errorf("%d: expected\n%v\ngot\n%v", i, tst.names, result)
 		}
 	}
 
 }
+
+func TestCdTime(t *testing.T) {
+	// collectd's time has a slightly lower resolution than go's
+	p := Packet{CdTime: cdTime(testDate)}
+	if d := p.Time().Sub(testDate); d < -time.Nanosecond || d > time.Nanosecond {
+		t.Errorf("expected %v, got %v", testDate, p.Time())
+	}
+	result := cdDuration(10 * time.Second)
+	if result != testPacket.CdInterval {
+		t.Errorf("expected %v, got %v", testPacket.CdInterval, result)
+	}
+}
+
+func TestPacketIdentifier(t *testing.T) {
+	tests := []struct {
+		p        Packet
+		expected string
+	}{
+		{Packet{Hostname: "laptop.lan", Plugin: "load", Type: "load"}, "laptop.lan/load/load"},
+		{Packet{Hostname: "laptop.lan", Plugin: "interface", PluginInstance: "lo0", Type: "if_octets"}, "laptop.lan/interface-lo0/if_octets"},
+		{Packet{Hostname: "laptop.lan", Plugin: "memory", Type: "memory", TypeInstance: "free"}, "laptop.lan/memory/memory-free"},
+		{Packet{Hostname: "laptop.lan", Plugin: "df", PluginInstance: "root", Type: "df_complex", TypeInstance: "used"}, "laptop.lan/df-root/df_complex-used"},
+	}
+	for _, test := range tests {
+		if id := test.p.Identifier(); id != test.expected {
+			t.Errorf("expected %q, got %q", test.expected, id)
+		}
+		p, err := ParseIdentifier(test.expected)
+		if err != nil || !reflect.DeepEqual(p, test.p) {
+			t.Errorf("%s: expected %v, got %v, %v", test.expected, test.p, p, err)
+		}
+	}
+
+	for _, s := range []string{"", "laptop.lan", "laptop.lan/load", "laptop.lan//load", "a/b/c/d"} {
+		if _, err := ParseIdentifier(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"iter"
+)
+
+// A Decoder reads packets from a stream of collectd data, such as a capture of
+// datagrams written one after another. Only one part is held in memory at a
+// time.
+//
+// As there are no datagram boundaries in a stream, the host, plugin, type and
+// time set by each part carry on until a later part changes them.
+type Decoder struct {
+	// Parser sets the options used to parse the stream. Signed data is
+	// unsupported, as each signature covers the rest of its datagram.
+	Parser Parser
+
+	// Notification is called with each notification in the stream, if it is
+	// not nil.
+	Notification func(n Notification)
+
+	r      *bufio.Reader
+	buf    []byte
+	offset int
+	state  parseState
+	result parseResult
+	next   int
+	err    error
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), result: parseResult{strings: stringTable{}}}
+}
+
+// Next returns the next packet in the stream. It returns io.EOF at the end of
+// the stream, or a *ParseError if the data can't be parsed. Once Next has
+// returned an error, it returns the same error every time it is called.
+func (d *Decoder) Next() (Packet, error) {
+	for d.next == len(d.result.packets) {
+		if d.err != nil {
+			return Packet{}, d.err
+		}
+		// packets that have been returned must not be reused
+		d.result.packets = nil
+		d.next = 0
+		d.err = d.readPart()
+
+		if d.Notification != nil {
+			for _, n := range d.result.notifications {
+				d.Notification(n)
+			}
+		}
+		d.result.notifications = d.result.notifications[:0]
+	}
+	p := d.result.packets[d.next]
+	d.next++
+	return p, nil
+}
+
+// All returns an iterator over the rest of the packets in the stream. If an
+// error happens it is yielded with an empty packet, and the iteration stops.
+// Reaching the end of the stream is not an error.
+func (d *Decoder) All() iter.Seq2[Packet, error] {
+	return func(yield func(Packet, error) bool) {
+		for {
+			p, err := d.Next()
+			if err == io.EOF {
+				return
+			}
+			if !yield(p, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readPart reads and parses the next part in the stream.
+func (d *Decoder) readPart() error {
+	offset := d.offset
+	var header [4]byte
+	n, err := io.ReadFull(d.r, header[:])
+	d.offset += n
+	if err == io.ErrUnexpectedEOF {
+		return &ParseError{offset, 0, 0, "part header is truncated", ErrorInvalid}
+	}
+	if err != nil {
+		return err
+	}
+
+	typ := binary.BigEndian.Uint16(header[0:2])
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < 5 {
+		return &ParseError{offset, typ, length, "part is too short", ErrorInvalid}
+	}
+
+	// parsePart copies anything it keeps, so the buffer can be reused
+	if cap(d.buf) < length-4 {
+		d.buf = make([]byte, length-4)
+	}
+	partBytes := d.buf[:length-4]
+	n, err = io.ReadFull(d.r, partBytes)
+	d.offset += n
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &ParseError{offset, typ, length, "part is longer than the remaining data", ErrorInvalid}
+	}
+	if err != nil {
+		return err
+	}
+
+	if typ == partSignature {
+		return &ParseError{offset, typ, length, "signed data can not be verified in a stream", ErrorUnsupported}
+	}
+	return d.Parser.parsePart(&d.state, offset, typ, partBytes, &d.result)
+}
@@ -0,0 +1,114 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"testing/iotest"
+)
+
+func TestDecoder(t *testing.T) {
+	// three datagrams, one after another
+	var stream []byte
+	stream = append(stream, h2b(testV5Data...)...)
+	stream = append(stream, h2b(testNotificationData...)...)
+	stream = append(stream, h2b(testEncryptedData...)...)
+
+	var expected []Packet
+	for _, d := range [][]string{testV5Data, testEncryptedData} {
+		packets, err := (&Parser{AuthDB: Passwords{"admin": "secret"}}).Parse(h2b(d...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, *packets...)
+	}
+
+	// reading one byte at a time checks parts split across reads
+	d := NewDecoder(iotest.OneByteReader(bytes.NewReader(stream)))
+	d.Parser.AuthDB = Passwords{"admin": "secret"}
+	var notifications []Notification
+	d.Notification = func(n Notification) {
+		notifications = append(notifications, n)
+	}
+	var result []Packet
+	for {
+		p, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		result = append(result, p)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected\n%v\ngot\n%v\n", expected, result)
+	}
+	if len(notifications) != 1 || notifications[0].Message != "Host unreachable" {
+		t.Errorf("expected a notification, got %v", notifications)
+	}
+
+	_, err := d.Next()
+	if err != io.EOF {
+		t.Errorf("expected '%v' again, got '%v'", io.EOF, err)
+	}
+}
+
+func TestDecoderAll(t *testing.T) {
+	d := NewDecoder(bytes.NewReader(h2b(testV5Data...)))
+	count := 0
+	for p, err := range d.All() {
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if p.Hostname != "laptop.lan" {
+			t.Errorf("expected laptop.lan, got %v", p.Hostname)
+		}
+		count++
+	}
+	if count != 3 {
+		t.Errorf("expected 3 packets, got %d", count)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	readErr := errors.New("read failed")
+	tests := []struct {
+		name    string
+		r       io.Reader
+		packets int
+		offset  int
+		err     error
+	}{
+		{"truncated header", bytes.NewReader(h2b(append(testUnsignedData[:3:3], "00 06")...)), 1, 41, ErrorInvalid},
+		{"truncated part", bytes.NewReader(h2b(append(testUnsignedData[:3:3], "00 06 00 0f 00")...)), 1, 41, ErrorInvalid},
+		{"short part", bytes.NewReader(h2b(append(testUnsignedData[:3:3], "00 06 00 03")...)), 1, 41, ErrorInvalid},
+		{"signed", bytes.NewReader(h2b(testSignedData...)), 0, 0, ErrorUnsupported},
+		{"read error", iotest.ErrReader(readErr), 0, -1, readErr},
+	}
+	for _, test := range tests {
+		d := NewDecoder(test.r)
+		packets := 0
+		var err error
+		for _, err = range d.All() {
+			if err == nil {
+				packets++
+			}
+		}
+		if packets != test.packets {
+			t.Errorf("%s: expected %d packets, got %d", test.name, test.packets, packets)
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected '%v', got '%v'", test.name, test.err, err)
+		}
+		var pe *ParseError
+		if errors.As(err, &pe) && pe.Offset != test.offset {
+			t.Errorf("%s: expected offset %d, got %d", test.name, test.offset, pe.Offset)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"strings"
+)
+
+// The error returned if a packet or notification can not be encoded
+var ErrorUnencodable = errors.New("Packet can not be encoded")
+
+// Encode encodes packets into the collectd binary protocol. Every part of
+// every packet is written, so the result is larger than the data collectd
+// sends; use a Writer to avoid repeating parts that have not changed.
+func Encode(packets []Packet) ([]byte, error) {
+	var b []byte
+	var err error
+	for _, p := range packets {
+		b, err = AppendPacket(b, p)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// EncodeNotifications encodes notifications into the collectd binary
+// protocol.
+func EncodeNotifications(notifications []Notification) ([]byte, error) {
+	var b []byte
+	var err error
+	for _, n := range notifications {
+		b, err = AppendNotification(b, n)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// AppendPacket appends the encoding of a packet to b.
+func AppendPacket(b []byte, p Packet) ([]byte, error) {
+	return appendPacket(b, p, nil)
+}
+
+// appendPacket appends the encoding of a packet to b. Like collectd, if last
+// is not nil only the parts that differ from last are written.
+func appendPacket(b []byte, p Packet, last *Packet) ([]byte, error) {
+	var err error
+	if last == nil || p.Hostname != last.Hostname {
+		b, err = appendString(b, partHost, p.Hostname)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if last == nil || p.CdTime != last.CdTime {
+		b = appendNumber(b, partTimeHR, p.CdTime)
+	}
+	if last == nil || p.CdInterval != last.CdInterval {
+		b = appendNumber(b, partIntervalHR, p.CdInterval)
+	}
+	if last == nil || p.Plugin != last.Plugin {
+		b, err = appendString(b, partPlugin, p.Plugin)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if last == nil || p.PluginInstance != last.PluginInstance {
+		b, err = appendString(b, partPluginInstance, p.PluginInstance)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if last == nil || p.Type != last.Type {
+		b, err = appendString(b, partType, p.Type)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if last == nil || p.TypeInstance != last.TypeInstance {
+		b, err = appendString(b, partTypeInstance, p.TypeInstance)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return appendValues(b, p)
+}
+
+// AppendNotification appends the encoding of a notification to b.
+func AppendNotification(b []byte, n Notification) ([]byte, error) {
+	switch n.Severity {
+	case SeverityFailure, SeverityWarning, SeverityOkay:
+	default:
+		return nil, ErrorUnencodable
+	}
+	b, err := appendString(b, partHost, n.Hostname)
+	if err != nil {
+		return nil, err
+	}
+	b = appendNumber(b, partTimeHR, n.CdTime)
+	b = appendNumber(b, partSeverity, uint64(n.Severity))
+	parts := []struct {
+		partType uint16
+		s        string
+	}{
+		{partPlugin, n.Plugin},
+		{partPluginInstance, n.PluginInstance},
+		{partType, n.Type},
+		{partTypeInstance, n.TypeInstance},
+	}
+	for _, part := range parts {
+		b, err = appendString(b, part.partType, part.s)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the message part has to come last, it's what makes this a notification
+	return appendString(b, partMessage, n.Message)
+}
+
+// appendString appends a null terminated string part.
+func appendString(b []byte, partType uint16, s string) ([]byte, error) {
+	if strings.IndexByte(s, 0) >= 0 || len(s)+5 > math.MaxUint16 {
+		return nil, ErrorUnencodable
+	}
+	b = binary.BigEndian.AppendUint16(b, partType)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(s)+5))
+	b = append(b, s...)
+	return append(b, 0), nil
+}
+
+// appendNumber appends a 64 bit number part.
+func appendNumber(b []byte, partType uint16, n uint64) []byte {
+	b = binary.BigEndian.AppendUint16(b, partType)
+	b = binary.BigEndian.AppendUint16(b, 12)
+	return binary.BigEndian.AppendUint64(b, n)
+}
+
+// appendValues appends the values part of a packet.
+func appendValues(b []byte, p Packet) ([]byte, error) {
+	count := len(p.DataTypes)
+	length := 6 + count*9
+	if count == 0 || len(p.Bytes) != count*8 || length > math.MaxUint16 {
+		return nil, ErrorUnencodable
+	}
+
+	b = binary.BigEndian.AppendUint16(b, partValues)
+	b = binary.BigEndian.AppendUint16(b, uint16(length))
+	b = binary.BigEndian.AppendUint16(b, uint16(count))
+	for _, t := range p.DataTypes {
+		if t > TypeAbsolute {
+			return nil, ErrorUnencodable
+		}
+		b = append(b, t)
+	}
+	for i, t := range p.DataTypes {
+		value := p.Bytes[i*8 : 8+(i*8)]
+		if t == TypeGauge {
+			// gauges are little endian in the protocol, see Parse
+			for j := 7; j >= 0; j-- {
+				b = append(b, value[j])
+			}
+		} else {
+			b = append(b, value...)
+		}
+	}
+	return b, nil
+}
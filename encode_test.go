@@ -0,0 +1,92 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	packets := []Packet{
+		{"laptop.lan", "memory", "", "memory", "wired", 1463827927039889790, 10737418240, []uint8{TypeGauge}, h2b("41 cf 43 00 00 00 00 00"), SecurityNone},
+	}
+	expected := h2b(
+		"00 00 00 0f 6c 61 70 74 6f 70 2e 6c 61 6e 00", // hostname: "laptop.lan"
+		"00 08 00 0c 14 50 8f be 73 82 51 7e",          // time, hi res
+		"00 09 00 0c 00 00 00 02 80 00 00 00",          // interval, hi res
+		"00 02 00 0b 6d 65 6d 6f 72 79 00",             // plugin: memory
+		"00 03 00 05 00",                               // plugin instance: nil
+		"00 04 00 0b 6d 65 6d 6f 72 79 00",             // type: memory
+		"00 05 00 0a 77 69 72 65 64 00",                // type instance: wired
+		"00 06 00 0f 00 01 01 00 00 00 00 00 43 cf 41", // value
+	)
+	result, err := Encode(packets)
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	} else if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected\n%v\ngot\n%v\n", expected, result)
+	}
+}
+
+func TestEncodeRoundTrip(t *testing.T) {
+	expected := []Packet{
+		testPacket,
+		{"laptop.lan", "interface", "lo0", "if_octets", "", 1463827927039906970, 10737418240, []uint8{TypeDerive, TypeDerive}, h2b("00 00 00 00 00 88 07 8b 00 00 00 00 00 88 07 8c"), SecurityNone},
+		{"laptop.lan", "swap", "", "swap_io", "in", 1463827927040016492, 10737418240, []uint8{TypeCounter, TypeAbsolute}, h2b("00 00 00 00 00 00 00 01 00 00 00 00 00 00 00 02"), SecurityNone},
+	}
+	b, err := Encode(expected)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	result, err := Parse(b)
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	} else if !reflect.DeepEqual(*result, expected) {
+		t.Errorf("expected\n%v\ngot\n%v\n", expected, *result)
+	}
+}
+
+func TestEncodeNotificationsRoundTrip(t *testing.T) {
+	expected := []Notification{
+		{"laptop.lan", "ping", "", "", "", 1463827927039889790, SeverityWarning, "Host unreachable", SecurityNone},
+		{"laptop.lan", "ping", "", "", "", 1463827927040016492, SeverityOkay, "Host reachable", SecurityNone},
+	}
+	b, err := EncodeNotifications(expected)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	_, result, err := ParseAll(b)
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	} else if !reflect.DeepEqual(*result, expected) {
+		t.Errorf("expected\n%v\ngot\n%v\n", expected, *result)
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet Packet
+	}{
+		{"no values", Packet{Hostname: "laptop.lan"}},
+		{"missing bytes", Packet{DataTypes: []uint8{TypeGauge}, Bytes: h2b("41 cf 43 00")}},
+		{"unknown type", Packet{DataTypes: []uint8{4}, Bytes: h2b("00 00 00 00 00 00 00 00")}},
+		{"null in string", Packet{Plugin: "mem\x00ory", DataTypes: []uint8{TypeGauge}, Bytes: h2b("41 cf 43 00 00 00 00 00")}},
+	}
+	for _, test := range tests {
+		result, err := Encode([]Packet{test.packet})
+		if err != ErrorUnencodable {
+			t.Errorf("%s: expected '%v', got '%v'", test.name, ErrorUnencodable, err)
+		}
+		if result != nil {
+			t.Errorf("%s: expected nil got %v", test.name, result)
+		}
+	}
+
+	_, err := EncodeNotifications([]Notification{{Message: "unknown severity"}})
+	if err != ErrorUnencodable {
+		t.Errorf("expected '%v', got '%v'", ErrorUnencodable, err)
+	}
+}
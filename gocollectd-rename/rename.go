@@ -0,0 +1,120 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+// gocollectd-rename prints how packets would be renamed by a file of rename
+// rules, without sending them anywhere.
+//
+// Packets are given as collectd identifiers, either as arguments or one per
+// line on stdin:
+//
+//	gocollectd-rename -rules rename.json laptop.lan/interface-lo0/if_octets
+//
+// or read from a file of recorded collectd datagrams:
+//
+//	gocollectd-rename -rules rename.json -capture packets.bin
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	collectd "github.com/paulhammond/gocollectd"
+)
+
+func main() {
+	rules := flag.String("rules", "", "the rename rules `file`")
+	types := flag.String("types", "", "a types.db `file` to add to the default types")
+	capture := flag.String("capture", "", "read packets from a `file` of collectd datagrams")
+	flag.Parse()
+	log.SetFlags(0)
+
+	if *rules == "" {
+		log.Fatal("-rules is required")
+	}
+	r, err := collectd.LoadRenamer(*rules)
+	if err != nil {
+		log.Fatal(err)
+	}
+	db := collectd.DefaultTypesDB()
+	if *types != "" {
+		if err := db.Load(*types); err != nil {
+			log.Fatal(err)
+		}
+	}
+	r.TypesDB = db
+
+	if *capture != "" {
+		f, err := os.Open(*capture)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		for p, err := range collectd.NewDecoder(f).All() {
+			if err != nil {
+				log.Fatal(err)
+			}
+			show(r, p, true)
+		}
+		return
+	}
+
+	identifiers := flag.Args()
+	if len(identifiers) == 0 {
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if line := strings.TrimSpace(scanner.Text()); line != "" {
+				identifiers = append(identifiers, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, id := range identifiers {
+		p, err := collectd.ParseIdentifier(id)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// identifiers have no values, so use one of each data source
+		sources, ok := db.Lookup(p.Type)
+		if !ok {
+			sources = []collectd.DataSource{{Name: "value", Type: collectd.TypeGauge}}
+		}
+		for _, ds := range sources {
+			p.DataTypes = append(p.DataTypes, ds.Type)
+		}
+		p.Bytes = make([]byte, 8*len(sources))
+		show(r, p, false)
+	}
+}
+
+// show prints the name and tags of each value in p, and its value if values
+// is set.
+func show(r *collectd.Renamer, p collectd.Packet, values bool) {
+	rule := "no rule, default name"
+	if i := r.Match(p); i >= 0 {
+		rule = fmt.Sprintf("rule %d", i+1)
+	}
+	fmt.Printf("%s (%s)\n", p.Identifier(), rule)
+	for _, m := range r.Rename(p) {
+		line := "  " + m.Name
+		if values {
+			n, _ := m.Value.Number()
+			line += fmt.Sprintf(" %v", n)
+		}
+		keys := make([]string, 0, len(m.Tags))
+		for k := range m.Tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			line += fmt.Sprintf(" %s=%q", k, m.Tags[k])
+		}
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"net"
+	"slices"
+	"time"
+)
+
+// A Request is a packet received by a Server, with details of where and when
+// it was received.
+type Request struct {
+	Packet Packet
+
+	// Addr is the address the packet was sent from.
+	Addr net.Addr
+
+	// Received is the time the datagram containing the packet was received.
+	Received time.Time
+}
+
+// Authenticated reports whether the packet was signed or encrypted by a
+// known user.
+func (r *Request) Authenticated() bool {
+	return r.Packet.Security >= SecuritySign
+}
+
+// A Handler handles packets received by a Server.
+type Handler interface {
+	ServeCollectd(r *Request)
+}
+
+// HandlerFunc allows an ordinary function to be used as a Handler.
+type HandlerFunc func(r *Request)
+
+// ServeCollectd calls f(r).
+func (f HandlerFunc) ServeCollectd(r *Request) {
+	f(r)
+}
+
+// ChanHandler returns a Handler that sends each packet over c.
+func ChanHandler(c chan<- Packet) Handler {
+	return HandlerFunc(func(r *Request) {
+		c <- r.Packet
+	})
+}
+
+// FanOut returns a Handler that passes each request to every handler in turn.
+// Each handler gets its own copy of the request, including the packet's data
+// types and values, so changes one handler makes are not seen by the others.
+func FanOut(handlers ...Handler) Handler {
+	return HandlerFunc(func(r *Request) {
+		for _, h := range handlers {
+			copy := *r
+			copy.Packet.DataTypes = slices.Clone(r.Packet.DataTypes)
+			copy.Packet.Bytes = slices.Clone(r.Packet.Bytes)
+			h.ServeCollectd(&copy)
+		}
+	})
+}
+
+// Middleware wraps a Handler to add behavior such as filtering or renaming.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with middleware. Requests pass through the middleware in the
+// order it is given before reaching h.
+func Chain(h Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return h
+}
+
+// Filter returns Middleware that only passes on requests for which keep
+// returns true.
+func Filter(keep func(r *Request) bool) Middleware {
+	return func(h Handler) Handler {
+		return HandlerFunc(func(r *Request) {
+			if keep(r) {
+				h.ServeCollectd(r)
+			}
+		})
+	}
+}
+
+// Rename returns Middleware that calls rename to change each packet before
+// passing it on.
+func Rename(rename func(p *Packet)) Middleware {
+	return func(h Handler) Handler {
+		return HandlerFunc(func(r *Request) {
+			rename(&r.Packet)
+			h.ServeCollectd(r)
+		})
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// recorder is a Handler that records the packets it receives.
+type recorder struct {
+	packets []Packet
+}
+
+func (h *recorder) ServeCollectd(r *Request) {
+	h.packets = append(h.packets, r.Packet)
+}
+
+func TestChain(t *testing.T) {
+	var order []string
+	middleware := func(name string) Middleware {
+		return func(h Handler) Handler {
+			return HandlerFunc(func(r *Request) {
+				order = append(order, name)
+				h.ServeCollectd(r)
+			})
+		}
+	}
+	h := Chain(&recorder{}, middleware("first"), middleware("second"))
+	h.ServeCollectd(&Request{Packet: testPacket})
+
+	expected := []string{"first", "second"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected %v, got %v", expected, order)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	rec := &recorder{}
+	h := Chain(rec, Filter(func(r *Request) bool {
+		return r.Packet.Plugin == "memory"
+	}))
+	h.ServeCollectd(&Request{Packet: Packet{Plugin: "memory"}})
+	h.ServeCollectd(&Request{Packet: Packet{Plugin: "load"}})
+
+	expected := []Packet{{Plugin: "memory"}}
+	if !reflect.DeepEqual(rec.packets, expected) {
+		t.Errorf("expected %v, got %v", expected, rec.packets)
+	}
+}
+
+func TestRenameAndFanOut(t *testing.T) {
+	renamed, original := &recorder{}, &recorder{}
+	upper := Rename(func(p *Packet) {
+		p.Plugin = strings.ToUpper(p.Plugin)
+	})
+	h := FanOut(Chain(renamed, upper), original)
+	h.ServeCollectd(&Request{Packet: Packet{Plugin: "memory"}})
+
+	if !reflect.DeepEqual(renamed.packets, []Packet{{Plugin: "MEMORY"}}) {
+		t.Errorf("expected renamed packet, got %v", renamed.packets)
+	}
+	if !reflect.DeepEqual(original.packets, []Packet{{Plugin: "memory"}}) {
+		t.Errorf("expected original packet, got %v", original.packets)
+	}
+}
+
+func TestFanOutValues(t *testing.T) {
+	original := &recorder{}
+	zero := HandlerFunc(func(r *Request) {
+		r.Packet.DataTypes[0] = TypeCounter
+		r.Packet.Bytes[0] = 0
+	})
+	h := FanOut(zero, original)
+	h.ServeCollectd(&Request{Packet: Packet{DataTypes: []uint8{TypeGauge}, Bytes: []byte{1}}})
+
+	expected := []Packet{{DataTypes: []uint8{TypeGauge}, Bytes: []byte{1}}}
+	if !reflect.DeepEqual(original.packets, expected) {
+		t.Errorf("expected original values, got %v", original.packets)
+	}
+}
+
+func TestRequestAuthenticated(t *testing.T) {
+	tests := map[SecurityLevel]bool{
+		SecurityNone:    false,
+		SecuritySign:    true,
+		SecurityEncrypt: true,
+	}
+	for level, expected := range tests {
+		r := Request{Packet: Packet{Security: level}}
+		if r.Authenticated() != expected {
+			t.Errorf("%v: expected %v", level, expected)
+		}
+	}
+}
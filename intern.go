@@ -0,0 +1,49 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"sync"
+)
+
+// maxInterned limits the number of strings kept by a stringTable, so data
+// with lots of different names can't use up memory.
+const maxInterned = 4096
+
+// maxInternedLen is the longest string a stringTable keeps. It is the same
+// as collectd's DATA_MAX_NAME_LEN, so real names are interned but a table
+// can't be filled with huge ones.
+const maxInternedLen = 128
+
+// A stringTable interns host, plugin and type names. They are repeated in
+// almost every datagram, so the same string is returned each time rather than
+// allocating a new one. A stringTable is not safe for concurrent use.
+type stringTable map[string]string
+
+// intern returns b as a string. When the table is full it is emptied, so
+// names that are no longer sent don't stay in it forever. A nil table
+// doesn't intern anything, and strings longer than maxInternedLen are never
+// interned.
+func (t stringTable) intern(b []byte) string {
+	if len(b) > maxInternedLen {
+		return string(b)
+	}
+	if s, ok := t[string(b)]; ok {
+		return s
+	}
+	s := string(b)
+	if t != nil {
+		if len(t) >= maxInterned {
+			clear(t)
+		}
+		t[s] = s
+	}
+	return s
+}
+
+// stringTables holds tables for calls to Parse and ParseInto. Each goroutine
+// takes its own table, so there is no lock to contend on.
+var stringTables = sync.Pool{
+	New: func() any { return stringTable{} },
+}
@@ -0,0 +1,61 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestStringTable(t *testing.T) {
+	table := stringTable{}
+	a := table.intern([]byte("laptop.lan"))
+	b := table.intern([]byte("laptop.lan"))
+	if a != "laptop.lan" || unsafe.StringData(a) != unsafe.StringData(b) {
+		t.Errorf("expected the same string to be returned")
+	}
+
+	// a full table is emptied, so new names are still interned
+	for i := 0; len(table) < maxInterned; i++ {
+		table.intern([]byte(strconv.Itoa(i)))
+	}
+	table.intern([]byte("new.lan"))
+	if len(table) != 1 || table["new.lan"] != "new.lan" {
+		t.Errorf("expected the table to be reset, got %d strings", len(table))
+	}
+
+	// long strings aren't kept
+	table.intern(make([]byte, maxInternedLen+1))
+	if len(table) != 1 {
+		t.Errorf("expected long strings not to be interned, got %d strings", len(table))
+	}
+
+	var empty stringTable
+	if s := empty.intern([]byte("laptop.lan")); s != "laptop.lan" {
+		t.Errorf("expected laptop.lan, got %q", s)
+	}
+}
+
+func TestDecoderLongNames(t *testing.T) {
+	p := testWriterPackets[0]
+	p.Hostname = strings.Repeat("a", 60000)
+	b, err := Encode([]Packet{p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewDecoder(bytes.NewReader(b))
+	decoded, err := d.Next()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if decoded.Hostname != p.Hostname {
+		t.Errorf("expected the long hostname to be parsed")
+	}
+	if _, ok := d.result.strings[p.Hostname]; ok || len(d.result.strings) != 3 {
+		t.Errorf("expected only the short names to be interned, got %d strings", len(d.result.strings))
+	}
+}
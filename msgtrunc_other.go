@@ -0,0 +1,10 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+//go:build !unix || aix
+
+package gocollectd
+
+// msgTrunc is zero on platforms where truncated datagrams can't be detected
+// using flags.
+const msgTrunc = 0
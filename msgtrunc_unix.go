@@ -0,0 +1,14 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+//go:build unix && !aix
+
+package gocollectd
+
+import (
+	"syscall"
+)
+
+// msgTrunc is the flag set by the kernel when a datagram was too large for the
+// buffer it was read into.
+const msgTrunc = syscall.MSG_TRUNC
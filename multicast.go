@@ -0,0 +1,81 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// The multicast groups collectd's network plugin uses by default.
+const (
+	DefaultIPv4Group = "239.192.74.66"
+	DefaultIPv6Group = "ff18::efc0:4a42"
+)
+
+// listenConfig returns the network and address a server should listen on,
+// and the multicast groups it should join. Like collectd, if the server's
+// address is a multicast group it listens on that group's address and port,
+// so only datagrams sent to the group are received. If more Groups are
+// joined, or the platform doesn't allow this, it listens on every address.
+func (s *Server) listenConfig() (network string, addr string, groups []net.IP, err error) {
+	network, addr = "udp", s.Addr
+
+	host, port, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsMulticast() {
+		network = "udp6"
+		if ip.To4() != nil {
+			network = "udp4"
+		}
+		if !bindGroups || len(s.Groups) > 0 {
+			addr = net.JoinHostPort("0.0.0.0", port)
+			if network == "udp6" {
+				addr = net.JoinHostPort("::", port)
+			}
+		}
+		groups = append(groups, ip)
+	}
+
+	for _, g := range s.Groups {
+		ip := net.ParseIP(g)
+		if ip == nil || !ip.IsMulticast() {
+			return "", "", nil, fmt.Errorf("%q is not a multicast group", g)
+		}
+		groups = append(groups, ip)
+	}
+	return network, addr, groups, nil
+}
+
+// joinGroups joins conn to each multicast group on the server's Interface.
+func (s *Server) joinGroups(conn net.PacketConn, groups []net.IP) error {
+	if len(groups) == 0 {
+		return nil
+	}
+	var ifi *net.Interface
+	if s.Interface != "" {
+		var err error
+		ifi, err = net.InterfaceByName(s.Interface)
+		if err != nil {
+			return err
+		}
+	}
+	for _, g := range groups {
+		var err error
+		if g.To4() != nil {
+			err = ipv4.NewPacketConn(conn).JoinGroup(ifi, &net.UDPAddr{IP: g})
+		} else {
+			err = ipv6.NewPacketConn(conn).JoinGroup(ifi, &net.UDPAddr{IP: g})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to join multicast group %v: %w", g, err)
+		}
+	}
+	return nil
+}
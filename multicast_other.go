@@ -0,0 +1,21 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+//go:build !unix
+
+package gocollectd
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// bindGroups is false as Windows, and other platforms without unix sockets,
+// only allow sockets to be bound to local addresses.
+var bindGroups = false
+
+// listenGroup is not used when bindGroups is false.
+func listenGroup(network string, addr *net.UDPAddr, control func(network, address string, c syscall.RawConn) error) (net.PacketConn, error) {
+	return nil, errors.New("collectd can not listen on a multicast address on this platform")
+}
@@ -0,0 +1,138 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestListenConfig(t *testing.T) {
+	defer func(b bool) { bindGroups = b }(bindGroups)
+
+	tests := []struct {
+		addr    string
+		groups  []string
+		bind    bool
+		network string
+		listen  string
+		join    []net.IP
+	}{
+		{"127.0.0.1:25826", nil, true, "udp", "127.0.0.1:25826", nil},
+		{"239.192.74.66:25826", nil, true, "udp4", "239.192.74.66:25826", []net.IP{net.ParseIP(DefaultIPv4Group)}},
+		{"[ff18::efc0:4a42]:25826", nil, true, "udp6", "[ff18::efc0:4a42]:25826", []net.IP{net.ParseIP(DefaultIPv6Group)}},
+		{"239.192.74.66:25826", nil, false, "udp4", "0.0.0.0:25826", []net.IP{net.ParseIP(DefaultIPv4Group)}},
+		{"[ff18::efc0:4a42]:25826", nil, false, "udp6", "[::]:25826", []net.IP{net.ParseIP(DefaultIPv6Group)}},
+		{
+			"239.192.74.66:25826", []string{"239.192.74.67"}, true, "udp4", "0.0.0.0:25826",
+			[]net.IP{net.ParseIP(DefaultIPv4Group), net.ParseIP("239.192.74.67")},
+		},
+		{
+			":25826", []string{DefaultIPv4Group, "239.192.74.67"}, true, "udp", ":25826",
+			[]net.IP{net.ParseIP(DefaultIPv4Group), net.ParseIP("239.192.74.67")},
+		},
+	}
+	for _, test := range tests {
+		bindGroups = test.bind
+		s := Server{Addr: test.addr, Groups: test.groups}
+		network, listen, join, err := s.listenConfig()
+		if err != nil {
+			t.Errorf("%s: expected no error, got %v", test.addr, err)
+		}
+		if network != test.network || listen != test.listen {
+			t.Errorf("%s: expected %s %s, got %s %s", test.addr, test.network, test.listen, network, listen)
+		}
+		if !reflect.DeepEqual(join, test.join) {
+			t.Errorf("%s: expected %v, got %v", test.addr, test.join, join)
+		}
+	}
+
+	s := Server{Addr: ":25826", Groups: []string{"127.0.0.1"}}
+	_, _, _, err := s.listenConfig()
+	if err == nil {
+		t.Errorf("expected an error joining a unicast address")
+	}
+}
+
+func TestServerMulticast(t *testing.T) {
+	for _, sockets := range []int{1, 2} {
+		t.Run(strconv.Itoa(sockets), func(t *testing.T) {
+			testServerMulticast(t, sockets)
+		})
+	}
+}
+
+func testServerMulticast(t *testing.T, sockets int) {
+	// find a free port
+	conn, err := net.ListenPacket("udp4", "0.0.0.0:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	c := make(chan Packet, 1)
+	s := Server{
+		Addr:    net.JoinHostPort(DefaultIPv4Group, strconv.Itoa(port)),
+		Sockets: sockets,
+		Handler: ChanHandler(c),
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.ListenAndServe(context.Background())
+	}()
+	defer s.Shutdown(context.Background())
+
+	client, err := net.Dial("udp4", s.Addr)
+	if err != nil {
+		t.Skipf("multicast is not available: %v", err)
+	}
+	defer client.Close()
+
+	// keep sending until the server has joined the group
+	received := false
+	for i := 0; i < 20 && !received; i++ {
+		client.Write(h2b(testUnsignedData...))
+		select {
+		case err := <-served:
+			t.Skipf("multicast is not available: %v", err)
+		case <-c:
+			received = true
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if !received {
+		t.Fatalf("expected a packet")
+	}
+	if !bindGroups {
+		return
+	}
+
+	// drain any other datagrams sent while waiting for the group to be
+	// joined
+	for drained := false; !drained; {
+		select {
+		case <-c:
+		case <-time.After(100 * time.Millisecond):
+			drained = true
+		}
+	}
+
+	// datagrams sent to the port on other addresses are not received
+	unicast, err := net.Dial("udp4", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unicast.Close()
+	unicast.Write(h2b(testUnsignedData...))
+	select {
+	case p := <-c:
+		t.Errorf("expected no packet, got %v", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+//go:build unix
+
+package gocollectd
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+var bindGroups = true
+
+// listenGroup listens on a multicast group's address and port. Given a
+// multicast address, net.ListenPacket listens on every address, so the socket
+// is created here instead. Like net.ListenConfig, control is called before
+// the socket is bound, if it is not nil.
+func listenGroup(network string, addr *net.UDPAddr, control func(network, address string, c syscall.RawConn) error) (net.PacketConn, error) {
+	var family int
+	var sa unix.Sockaddr
+	if ip := addr.IP.To4(); ip != nil {
+		family = unix.AF_INET
+		sa4 := &unix.SockaddrInet4{Port: addr.Port}
+		copy(sa4.Addr[:], ip)
+		sa = sa4
+	} else {
+		family = unix.AF_INET6
+		sa6 := &unix.SockaddrInet6{Port: addr.Port}
+		copy(sa6.Addr[:], addr.IP.To16())
+		sa = sa6
+	}
+
+	syscall.ForkLock.RLock()
+	fd, err := unix.Socket(family, unix.SOCK_DGRAM, unix.IPPROTO_UDP)
+	if err == nil {
+		unix.CloseOnExec(fd)
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	f := os.NewFile(uintptr(fd), "udp:"+addr.String())
+	defer f.Close()
+
+	// like collectd, let other programs listen to the group too
+	err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1)
+	if err != nil {
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	if control != nil {
+		rc, err := f.SyscallConn()
+		if err != nil {
+			return nil, err
+		}
+		err = control(network, addr.String(), rc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = unix.Bind(fd, sa)
+	if err != nil {
+		return nil, &net.OpError{Op: "listen", Net: network, Addr: addr, Err: os.NewSyscallError("bind", err)}
+	}
+	return net.FilePacketConn(f)
+}
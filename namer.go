@@ -0,0 +1,322 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A Namer turns collectd's host/plugin/type/instance hierarchy into names for
+// the values in a packet, for systems that store metrics by name.
+type Namer interface {
+	// Name returns a name for the packet as a whole.
+	Name(p Packet) string
+	// ValueNames returns a name for each value in the packet.
+	ValueNames(p Packet) []string
+}
+
+// dataSourceNames returns the names of a packet's data sources in db, or
+// their indexes if the packet doesn't match its type.
+func dataSourceNames(db TypesDB, p Packet) []string {
+	names, err := p.DataSourceNames(db)
+	if err == nil {
+		return names
+	}
+	names = make([]string, len(p.DataTypes))
+	for i := range names {
+		names[i] = strconv.Itoa(i)
+	}
+	return names
+}
+
+// LegacyNamer is the naming scheme used by Packet.Name and Packet.ValueNames.
+// It has special cases for the df, interface, load and memory plugins, and
+// numbers the values of everything else.
+type LegacyNamer struct{}
+
+// Name returns a name for the packet.
+func (LegacyNamer) Name(p Packet) (name string) {
+	// todo: collectd 4 uses different patterns for some plugins
+	// https://collectd.org/wiki/index.php/V4_to_v5_migration_guide
+	switch p.Plugin {
+	case "df":
+		name = fmt.Sprintf("df_%s_%s", p.PluginInstance, p.TypeInstance)
+	case "interface":
+		name = fmt.Sprintf("%s_%s", p.Type, p.PluginInstance)
+	case "load":
+		name = "load"
+	case "memory":
+		name = fmt.Sprintf("memory_%s", p.TypeInstance)
+	default:
+		name = fmt.Sprintf("%s_%s_%s_%s", p.Plugin, p.PluginInstance, p.Type, p.TypeInstance)
+	}
+	return name
+}
+
+// ValueNames returns a name for each value in the packet. Interface values
+// are named tx and rx in that order, which is the reverse of the order
+// collectd sends them in; this is kept for compatibility.
+func (n LegacyNamer) ValueNames(p Packet) []string {
+	r := make([]string, len(p.DataTypes))
+	name := n.Name(p)
+	for i := range p.DataTypes {
+		var valueName string
+		switch {
+		case p.Plugin == "df" && i == 0:
+			valueName = ""
+		case p.Plugin == "memory" && i == 0:
+			valueName = ""
+		case p.Plugin == "interface" && i == 0:
+			valueName = "tx"
+		case p.Plugin == "interface" && i == 1:
+			valueName = "rx"
+		case p.Plugin == "load" && i == 0:
+			valueName = "1"
+		case p.Plugin == "load" && i == 1:
+			valueName = "5"
+		case p.Plugin == "load" && i == 2:
+			valueName = "15"
+		default:
+			valueName = strconv.FormatInt(int64(i), 10)
+		}
+		if valueName == "" {
+			r[i] = name
+		} else {
+			r[i] = fmt.Sprintf("%s_%s", name, valueName)
+		}
+	}
+	return r
+}
+
+// IdentifierNamer names packets with collectd's own identifiers, in the form
+// host/plugin-instance/type-instance. Each value adds its data source name,
+// as in host/interface-lo0/if_octets:rx.
+type IdentifierNamer struct {
+	// TypesDB holds the data source names. If it is nil the default TypesDB
+	// is used.
+	TypesDB TypesDB
+}
+
+// Name returns the packet's identifier.
+func (n IdentifierNamer) Name(p Packet) string {
+	return p.Identifier()
+}
+
+// ValueNames returns the packet's identifier followed by the name of each
+// data source.
+func (n IdentifierNamer) ValueNames(p Packet) []string {
+	id := p.Identifier()
+	names := dataSourceNames(n.TypesDB, p)
+	for i, ds := range names {
+		names[i] = id + ":" + ds
+	}
+	return names
+}
+
+// GraphiteNamer names values in the same way as collectd's write_graphite
+// plugin, such as laptop_lan.interface-lo0.if_octets.rx. The fields match
+// the plugin's options of the same name.
+type GraphiteNamer struct {
+	// TypesDB holds the data source names. If it is nil the default TypesDB
+	// is used.
+	TypesDB TypesDB
+
+	// Prefix and Postfix are added before and after the hostname.
+	Prefix  string
+	Postfix string
+
+	// EscapeCharacter replaces dots and other characters graphite treats
+	// specially. The default is '_'.
+	EscapeCharacter byte
+
+	// SeparateInstances separates instances from plugin and type names
+	// with a dot instead of a dash.
+	SeparateInstances bool
+
+	// AlwaysAppendDS adds the data source name to values of types with one
+	// data source.
+	AlwaysAppendDS bool
+
+	// PreserveSeparator stops dots in names being escaped.
+	PreserveSeparator bool
+}
+
+// escape replaces characters that graphite treats specially.
+func (n GraphiteNamer) escape(s string) string {
+	c := n.EscapeCharacter
+	if c == 0 {
+		c = '_'
+	}
+	return strings.Map(func(r rune) rune {
+		if r == '.' && n.PreserveSeparator {
+			return r
+		}
+		if strings.ContainsRune(". \t\r\n\"\\:!/()", r) {
+			return rune(c)
+		}
+		return r
+	}, s)
+}
+
+// Name returns the graphite path of the packet, without data source names.
+func (n GraphiteNamer) Name(p Packet) string {
+	sep := "-"
+	if n.SeparateInstances {
+		sep = "."
+	}
+	name := n.Prefix + n.escape(p.Hostname) + n.Postfix + "." + n.escape(p.Plugin)
+	if p.PluginInstance != "" {
+		name += sep + n.escape(p.PluginInstance)
+	}
+	name += "." + n.escape(p.Type)
+	if p.TypeInstance != "" {
+		name += sep + n.escape(p.TypeInstance)
+	}
+	return name
+}
+
+// ValueNames returns the graphite path of each value.
+func (n GraphiteNamer) ValueNames(p Packet) []string {
+	name := n.Name(p)
+	names := dataSourceNames(n.TypesDB, p)
+	for i, ds := range names {
+		if len(names) > 1 || n.AlwaysAppendDS {
+			names[i] = name + "." + n.escape(ds)
+		} else {
+			names[i] = name
+		}
+	}
+	return names
+}
+
+// PrometheusNamer names values in the same way as the Prometheus
+// collectd_exporter, such as
+// collectd_interface_if_octets_rx_total{instance="laptop.lan",interface="lo0"}.
+type PrometheusNamer struct {
+	// TypesDB holds the data source names. If it is nil the default TypesDB
+	// is used.
+	TypesDB TypesDB
+}
+
+// Name returns the metric name and labels shared by the packet's values.
+func (n PrometheusNamer) Name(p Packet) string {
+	return n.metric(p) + n.labels(p)
+}
+
+// ValueNames returns the metric name and labels of each value. Data sources
+// other than "value" are added to the name, and counter and derive values
+// end in _total.
+func (n PrometheusNamer) ValueNames(p Packet) []string {
+	metric, labels := n.metric(p), n.labels(p)
+	names := dataSourceNames(n.TypesDB, p)
+	for i, ds := range names {
+		name := metric
+		if ds != "value" {
+			name += "_" + ds
+		}
+		if p.DataTypes[i] == TypeCounter || p.DataTypes[i] == TypeDerive {
+			name += "_total"
+		}
+		names[i] = prometheusName(name) + labels
+	}
+	return names
+}
+
+// metric returns the start of the packet's metric names.
+func (n PrometheusNamer) metric(p Packet) string {
+	if p.Plugin == p.Type {
+		return prometheusName("collectd_" + p.Type)
+	}
+	return prometheusName("collectd_" + p.Plugin + "_" + p.Type)
+}
+
+// labels returns the packet's labels, sorted by name.
+func (n PrometheusNamer) labels(p Packet) string {
+	labels := map[string]string{"instance": p.Hostname}
+	if p.PluginInstance != "" {
+		labels[p.Plugin] = p.PluginInstance
+	}
+	if p.TypeInstance != "" {
+		if p.PluginInstance == "" {
+			labels[p.Plugin] = p.TypeInstance
+		} else {
+			labels["type"] = p.TypeInstance
+		}
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(prometheusName(k))
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(labels[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// labelEscaper escapes label values in the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// prometheusName replaces characters that aren't allowed in Prometheus metric
+// and label names with underscores.
+func prometheusName(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == ':' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// A PluginNamer uses different naming schemes for different plugins.
+type PluginNamer struct {
+	// Default names packets from plugins without a rule. If it is nil
+	// LegacyNamer is used.
+	Default Namer
+
+	plugins map[string]Namer
+}
+
+// Register makes n name the packets from a plugin, replacing any earlier rule
+// for the plugin.
+func (pn *PluginNamer) Register(plugin string, n Namer) {
+	if pn.plugins == nil {
+		pn.plugins = map[string]Namer{}
+	}
+	pn.plugins[plugin] = n
+}
+
+// namer returns the Namer for a packet.
+func (pn *PluginNamer) namer(p Packet) Namer {
+	if n, ok := pn.plugins[p.Plugin]; ok {
+		return n
+	}
+	if pn.Default != nil {
+		return pn.Default
+	}
+	return LegacyNamer{}
+}
+
+// Name returns a name for the packet, using the Namer for its plugin.
+func (pn *PluginNamer) Name(p Packet) string {
+	return pn.namer(p).Name(p)
+}
+
+// ValueNames returns a name for each value, using the Namer for the packet's
+// plugin.
+func (pn *PluginNamer) ValueNames(p Packet) []string {
+	return pn.namer(p).ValueNames(p)
+}
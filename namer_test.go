@@ -0,0 +1,127 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var (
+	testNamerInterface = Packet{"laptop.lan", "interface", "lo0", "if_octets", "", 0, 0, []uint8{TypeDerive, TypeDerive}, make([]byte, 16), SecurityNone}
+	testNamerMemory    = Packet{"laptop.lan", "memory", "", "memory", "wired", 0, 0, []uint8{TypeGauge}, make([]byte, 8), SecurityNone}
+	testNamerDF        = Packet{"laptop.lan", "df", "root", "df_complex", "used", 0, 0, []uint8{TypeGauge}, make([]byte, 8), SecurityNone}
+	testNamerUnknown   = Packet{"laptop.lan", "my plugin", "", "custom", "", 0, 0, []uint8{TypeGauge, TypeCounter}, make([]byte, 16), SecurityNone}
+)
+
+func TestNamers(t *testing.T) {
+	tests := []struct {
+		name   string
+		namer  Namer
+		packet Packet
+		out    string
+		values []string
+	}{
+		{"legacy", LegacyNamer{}, testNamerInterface, "if_octets_lo0", []string{"if_octets_lo0_tx", "if_octets_lo0_rx"}},
+		{"legacy", LegacyNamer{}, testNamerMemory, "memory_wired", []string{"memory_wired"}},
+
+		{"identifier", IdentifierNamer{}, testNamerInterface, "laptop.lan/interface-lo0/if_octets", []string{"laptop.lan/interface-lo0/if_octets:rx", "laptop.lan/interface-lo0/if_octets:tx"}},
+		{"identifier", IdentifierNamer{}, testNamerMemory, "laptop.lan/memory/memory-wired", []string{"laptop.lan/memory/memory-wired:value"}},
+		{"identifier", IdentifierNamer{}, testNamerUnknown, "laptop.lan/my plugin/custom", []string{"laptop.lan/my plugin/custom:0", "laptop.lan/my plugin/custom:1"}},
+
+		{"graphite", GraphiteNamer{}, testNamerInterface, "laptop_lan.interface-lo0.if_octets", []string{"laptop_lan.interface-lo0.if_octets.rx", "laptop_lan.interface-lo0.if_octets.tx"}},
+		{"graphite", GraphiteNamer{}, testNamerMemory, "laptop_lan.memory.memory-wired", []string{"laptop_lan.memory.memory-wired"}},
+		{"graphite", GraphiteNamer{}, testNamerUnknown, "laptop_lan.my_plugin.custom", []string{"laptop_lan.my_plugin.custom.0", "laptop_lan.my_plugin.custom.1"}},
+		{
+			"graphite options",
+			GraphiteNamer{Prefix: "collectd.", Postfix: ".host", EscapeCharacter: '-', SeparateInstances: true, AlwaysAppendDS: true},
+			testNamerMemory,
+			"collectd.laptop-lan.host.memory.memory.wired",
+			[]string{"collectd.laptop-lan.host.memory.memory.wired.value"},
+		},
+		{"graphite preserve", GraphiteNamer{PreserveSeparator: true}, testNamerDF, "laptop.lan.df-root.df_complex-used", []string{"laptop.lan.df-root.df_complex-used"}},
+
+		{
+			"prometheus",
+			PrometheusNamer{},
+			testNamerInterface,
+			`collectd_interface_if_octets{instance="laptop.lan",interface="lo0"}`,
+			[]string{`collectd_interface_if_octets_rx_total{instance="laptop.lan",interface="lo0"}`, `collectd_interface_if_octets_tx_total{instance="laptop.lan",interface="lo0"}`},
+		},
+		{
+			"prometheus",
+			PrometheusNamer{},
+			testNamerMemory,
+			`collectd_memory{instance="laptop.lan",memory="wired"}`,
+			[]string{`collectd_memory{instance="laptop.lan",memory="wired"}`},
+		},
+		{
+			"prometheus",
+			PrometheusNamer{},
+			testNamerDF,
+			`collectd_df_df_complex{df="root",instance="laptop.lan",type="used"}`,
+			[]string{`collectd_df_df_complex{df="root",instance="laptop.lan",type="used"}`},
+		},
+		{
+			"prometheus",
+			PrometheusNamer{},
+			testNamerUnknown,
+			`collectd_my_plugin_custom{instance="laptop.lan"}`,
+			[]string{`collectd_my_plugin_custom_0{instance="laptop.lan"}`, `collectd_my_plugin_custom_1_total{instance="laptop.lan"}`},
+		},
+	}
+	for _, test := range tests {
+		if name := test.namer.Name(test.packet); name != test.out {
+			t.Errorf("%s: expected %q, got %q", test.name, test.out, name)
+		}
+		if values := test.namer.ValueNames(test.packet); !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.values, values)
+		}
+	}
+}
+
+func TestNamerTypesDB(t *testing.T) {
+	db, _ := ParseTypesDB(strings.NewReader("custom a:GAUGE:U:U, b:COUNTER:U:U\n"))
+	n := PrometheusNamer{TypesDB: db}
+	expected := []string{`collectd_my_plugin_custom_a{instance="laptop.lan"}`, `collectd_my_plugin_custom_b_total{instance="laptop.lan"}`}
+	if values := n.ValueNames(testNamerUnknown); !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %q, got %q", expected, values)
+	}
+}
+
+func TestPrometheusLabelEscaping(t *testing.T) {
+	p := testNamerMemory
+	p.Hostname = `a "quoted"\host` + "\n"
+	expected := `collectd_memory{instance="a \"quoted\"\\host\n",memory="wired"}`
+	if name := (PrometheusNamer{}).Name(p); name != expected {
+		t.Errorf("expected %q, got %q", expected, name)
+	}
+}
+
+func TestPluginNamer(t *testing.T) {
+	var n PluginNamer
+	n.Register("interface", GraphiteNamer{})
+	n.Register("memory", IdentifierNamer{})
+	n.Register("memory", PrometheusNamer{})
+
+	tests := []struct {
+		packet Packet
+		values []string
+	}{
+		{testNamerInterface, []string{"laptop_lan.interface-lo0.if_octets.rx", "laptop_lan.interface-lo0.if_octets.tx"}},
+		{testNamerMemory, []string{`collectd_memory{instance="laptop.lan",memory="wired"}`}},
+		{testNamerDF, []string{"df_root_used"}},
+	}
+	for _, test := range tests {
+		if values := n.ValueNames(test.packet); !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%s: expected %q, got %q", test.packet.Plugin, test.values, values)
+		}
+	}
+
+	n.Default = IdentifierNamer{}
+	if name := n.Name(testNamerDF); name != "laptop.lan/df-root/df_complex-used" {
+		t.Errorf("expected the default namer to be used, got %q", name)
+	}
+}
@@ -0,0 +1,8 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+//go:build !race
+
+package gocollectd
+
+const raceEnabled = false
@@ -0,0 +1,59 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"time"
+)
+
+// Severity is how serious a collectd notification is.
+type Severity int
+
+const (
+	SeverityFailure Severity = 1
+	SeverityWarning Severity = 2
+	SeverityOkay    Severity = 4
+)
+
+// String returns the name collectd uses for this severity.
+func (s Severity) String() string {
+	switch s {
+	case SeverityFailure:
+		return "FAILURE"
+	case SeverityWarning:
+		return "WARNING"
+	case SeverityOkay:
+		return "OKAY"
+	}
+	return "UNKNOWN"
+}
+
+// A Notification is a message sent by collectd, such as a threshold alert.
+type Notification struct {
+	Hostname       string
+	Plugin         string
+	PluginInstance string
+	Type           string
+	TypeInstance   string
+	CdTime         uint64
+	Severity       Severity
+	Message        string
+	Security       SecurityLevel
+}
+
+// TimeUnixNano returns the notification time in nanoseconds since unix epoch.
+func (n Notification) TimeUnixNano() int64 {
+	// 1.0737... is 2^30 (collectds' subsecond interval) / 10^-9 (nanoseconds)
+	return int64(float64(n.CdTime) / 1.073741824)
+}
+
+// TimeUnix returns the notification time in seconds since unix epoch.
+func (n Notification) TimeUnix() int64 {
+	return int64(n.CdTime >> 30)
+}
+
+// Time returns the notification time as a go time.
+func (n Notification) Time() time.Time {
+	return time.Unix(0, n.TimeUnixNano())
+}
@@ -0,0 +1,63 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testNotificationData = []string{
+	"00 00 00 0f 6c 61 70 74 6f 70 2e 6c 61 6e 00",                   // hostname: "laptop.lan"
+	"00 08 00 0c 14 50 8f be 73 82 51 7e",                            // time, hi res
+	"01 01 00 0c 00 00 00 00 00 00 00 02",                            // severity: warning
+	"00 02 00 09 70 69 6e 67 00",                                     // plugin: ping
+	"01 00 00 15 48 6f 73 74 20 75 6e 72 65 61 63 68 61 62 6c 65 00", // message: "Host unreachable"
+}
+
+func TestParseNotification(t *testing.T) {
+	b := h2b(testNotificationData...)
+	b = append(b, h2b(
+		"01 01 00 0c 00 00 00 00 00 00 00 03",                            // severity: unknown
+		"01 00 00 15 48 6f 73 74 20 75 6e 72 65 61 63 68 61 62 6c 65 00", // message: "Host unreachable"
+		"00 06 00 0f 00 01 01 00 00 00 00 00 43 cf 41",                   // value
+	)...)
+	expectedPackets := []Packet{
+		{"laptop.lan", "ping", "", "", "", 1463827927039889790, 0, []uint8{TypeGauge}, h2b("41 cf 43 00 00 00 00 00"), SecurityNone},
+	}
+	expectedNotifications := []Notification{
+		{"laptop.lan", "ping", "", "", "", 1463827927039889790, SeverityWarning, "Host unreachable", SecurityNone},
+	}
+	packets, notifications, err := ParseAll(b)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(*packets, expectedPackets) {
+		t.Errorf("expected\n%v\ngot\n%v\n", expectedPackets, *packets)
+	}
+	if !reflect.DeepEqual(*notifications, expectedNotifications) {
+		t.Errorf("expected\n%v\ngot\n%v\n", expectedNotifications, *notifications)
+	}
+}
+
+func TestNotificationTime(t *testing.T) {
+	n := Notification{CdTime: testPacket.CdTime}
+	if !n.Time().Equal(testDate) {
+		t.Errorf("expected %v, got %v", testDate, n.Time())
+	}
+}
+
+func TestSeverityString(t *testing.T) {
+	tests := map[Severity]string{
+		SeverityFailure: "FAILURE",
+		SeverityWarning: "WARNING",
+		SeverityOkay:    "OKAY",
+		Severity(3):     "UNKNOWN",
+	}
+	for s, expected := range tests {
+		if s.String() != expected {
+			t.Errorf("expected %v, got %v", expected, s.String())
+		}
+	}
+}
@@ -4,9 +4,10 @@
 package gocollectd
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"slices"
 )
 
 // The error returned if a valid but unsupported packet is recieved
@@ -15,118 +16,364 @@ var ErrorUnsupported = errors.New("Unsupported collectd packet recieved")
 // The error returned if an invalid packet is recieved
 var ErrorInvalid = errors.New("Invalid collectd packet recieved")
 
+// Part types used by the collectd binary protocol.
+const (
+	partHost           = 0x0000
+	partTime           = 0x0001
+	partPlugin         = 0x0002
+	partPluginInstance = 0x0003
+	partType           = 0x0004
+	partTypeInstance   = 0x0005
+	partValues         = 0x0006
+	partInterval       = 0x0007
+	partTimeHR         = 0x0008
+	partIntervalHR     = 0x0009
+	partMessage        = 0x0100
+	partSeverity       = 0x0101
+	partSignature      = 0x0200
+	partEncryption     = 0x0210
+)
+
+// A Parser parses collectd data using a set of options. The zero value
+// parses data in the same way as Parse.
+type Parser struct {
+	// AuthDB is used to look up passwords when verifying signed data and
+	// decrypting encrypted data. If it is nil then signed and encrypted data
+	// is unsupported.
+	AuthDB AuthDB
+
+	// SkipUnknown makes the parser skip parts with types it doesn't know,
+	// instead of failing with ErrorUnsupported. This lets data from newer
+	// versions of collectd be read.
+	SkipUnknown bool
+
+	// Skipped is called with each part that is skipped, if it is not nil.
+	Skipped func(part UnknownPart)
+
+	// TypesDB is used to validate packets, if it is not nil. Packets that
+	// don't match their type are dropped.
+	TypesDB TypesDB
+
+	// Invalid is called with the reason each packet was dropped by
+	// validation, if it is not nil.
+	Invalid func(err *ValidationError)
+}
+
+// An UnknownPart is a part of collectd data with a type the parser doesn't
+// know.
+type UnknownPart struct {
+	// Offset is the position of the part in the data. Inside encrypted data
+	// it is the position in the decrypted data.
+	Offset int
+	Type   uint16
+	// Data is the part's contents, without its header.
+	Data []byte
+}
+
 // Parse parses some bytes into packets.
 func Parse(b []byte) (*[]Packet, error) {
-	r := make([]Packet, 0)
+	var ps Parser
+	return ps.Parse(b)
+}
+
+// ParseAll parses some bytes into packets and notifications.
+func ParseAll(b []byte) (*[]Packet, *[]Notification, error) {
+	var ps Parser
+	return ps.ParseAll(b)
+}
+
+// ParseInto parses some bytes into packets, appending them to dst.
+func ParseInto(dst []Packet, b []byte) ([]Packet, error) {
+	var ps Parser
+	return ps.ParseInto(dst, b)
+}
 
-	buf := bytes.NewBuffer(b)
-	var p Packet
-	var packetHeader struct {
-		PartType   uint16
-		PartLength uint16
+// Parse parses some bytes into packets. Packets that were signed are marked
+// with SecuritySign, and packets that were encrypted with SecurityEncrypt.
+func (ps *Parser) Parse(b []byte) (*[]Packet, error) {
+	packets, _, err := ps.ParseAll(b)
+	return packets, err
+}
+
+// ParseAll parses some bytes into packets and notifications.
+func (ps *Parser) ParseAll(b []byte) (*[]Packet, *[]Notification, error) {
+	r, err := ps.parseAll(b)
+	if err != nil {
+		return nil, nil, err
 	}
-	var time uint64
-	var err error
-	var valueCount uint16
+	return &r.packets, &r.notifications, nil
+}
 
-	for buf.Len() > 0 {
-		err = binary.Read(buf, binary.BigEndian, &packetHeader)
-		if err != nil {
-			return nil, err
-		}
-		if packetHeader.PartLength < 5 {
-			return nil, ErrorInvalid
+// ParseInto parses some bytes into packets, appending them to dst and
+// returning the extended slice. Notifications are ignored.
+//
+// ParseInto reuses memory where it can, so it can be called repeatedly
+// without allocating. The DataTypes and Bytes of packets in dst's spare
+// capacity are overwritten, so passing dst[:0] reuses the packets from a
+// previous call. If there is an error, dst is returned unchanged.
+func (ps *Parser) ParseInto(dst []Packet, b []byte) ([]Packet, error) {
+	r := parseResult{packets: dst, strings: stringTables.Get().(stringTable)}
+	defer stringTables.Put(r.strings)
+	err := ps.parse(b, 0, SecurityNone, &r)
+	if err != nil {
+		return dst, err
+	}
+	return r.packets, nil
+}
+
+// parseResult holds the packets and notifications found while parsing, and
+// counts the parts that were skipped and the packets that were invalid.
+type parseResult struct {
+	packets       []Packet
+	notifications []Notification
+	skipped       int
+	invalid       int
+	// strings interns the names in the packets
+	strings stringTable
+}
+
+// valueStorage returns slices to hold the data types and bytes of count
+// values. They reuse the storage of the packet in the spare capacity of
+// r.packets that the next packet will be written over.
+func (r *parseResult) valueStorage(count int) ([]uint8, []byte) {
+	var dataTypes []uint8
+	var b []byte
+	if n := len(r.packets); n < cap(r.packets) {
+		next := r.packets[: n+1 : n+1][n]
+		dataTypes, b = next.DataTypes[:0], next.Bytes[:0]
+	}
+	return slices.Grow(dataTypes, count)[:count], slices.Grow(b, 8*count)[:8*count]
+}
+
+// parseAll parses b, returning everything found before any error.
+func (ps *Parser) parseAll(b []byte) (parseResult, *ParseError) {
+	r := parseResult{packets: make([]Packet, 0), notifications: make([]Notification, 0)}
+	r.strings = stringTables.Get().(stringTable)
+	defer stringTables.Put(r.strings)
+	err := ps.parse(b, 0, SecurityNone, &r)
+	if err != nil {
+		return r, err.(*ParseError)
+	}
+	return r, nil
+}
+
+// A ParseError describes the part of some collectd data that could not be
+// parsed. Err is one of the package's errors, such as ErrorInvalid or
+// ErrorUnsupported, so ParseErrors can be checked using errors.Is.
+type ParseError struct {
+	// Offset is the position of the part in the data.
+	Offset int
+	// PartType and Length are from the part's header. They are zero if the
+	// header could not be read.
+	PartType uint16
+	Length   int
+	// Reason describes what was wrong with the part.
+	Reason string
+	Err    error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%v: %s in part type 0x%04x with length %d at offset %d", e.Err, e.Reason, e.PartType, e.Length, e.Offset)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// parseState holds the values that carry on from one part to the next.
+type parseState struct {
+	p        Packet
+	severity uint64
+}
+
+// parse parses b, which starts at offset base in the datagram, and appends
+// the packets and notifications found to r. Errors are returned as a
+// *ParseError.
+func (ps *Parser) parse(b []byte, base int, security SecurityLevel, r *parseResult) error {
+	st := parseState{p: Packet{Security: security}}
+
+	for pos := 0; pos < len(b); {
+		offset := base + pos
+		var typ uint16
+		var length int
+		fail := func(err error, reason string) error {
+			return &ParseError{offset, typ, length, reason, err}
 		}
 
-		partBytes := buf.Next(int(packetHeader.PartLength) - 4)
-		if len(partBytes) < int(packetHeader.PartLength)-4 {
-			return nil, ErrorInvalid
+		if len(b)-pos < 4 {
+			return fail(ErrorInvalid, "part header is truncated")
 		}
-		partBuffer := bytes.NewBuffer(partBytes)
+		typ = binary.BigEndian.Uint16(b[pos:])
+		length = int(binary.BigEndian.Uint16(b[pos+2:]))
+		if length < 5 {
+			return fail(ErrorInvalid, "part is too short")
+		}
+		if length > len(b)-pos {
+			return fail(ErrorInvalid, "part is longer than the remaining data")
+		}
+		partBytes := b[pos+4 : pos+length]
+		pos += length
 
-		switch packetHeader.PartType {
-		case 0:
-			str := partBuffer.String()
-			p.Hostname = str[0 : len(str)-1]
-		case 1:
-			err = binary.Read(partBuffer, binary.BigEndian, &time)
-			if err != nil {
-				return nil, err
+		if typ == partSignature {
+			// Signature (HMAC-SHA-256)
+			if ps.AuthDB == nil {
+				return fail(ErrorUnsupported, "signed data needs an AuthDB")
 			}
-			p.CdTime = time << 30
-		case 2:
-			str := partBuffer.String()
-			p.Plugin = str[0 : len(str)-1]
-		case 3:
-			str := partBuffer.String()
-			p.PluginInstance = str[0 : len(str)-1]
-		case 4:
-			str := partBuffer.String()
-			p.Type = str[0 : len(str)-1]
-		case 5:
-			str := partBuffer.String()
-			p.TypeInstance = str[0 : len(str)-1]
-		case 6:
-			err = binary.Read(partBuffer, binary.BigEndian, &valueCount)
+			err := verifySignature(ps.AuthDB, partBytes, b[pos:])
 			if err != nil {
-				return nil, err
+				return fail(err, "signature could not be verified")
 			}
+			// like collectd, parse the signed data from a clean state
+			if security < SecuritySign {
+				security = SecuritySign
+			}
+			return ps.parse(b[pos:], base+pos, security, r)
+		}
 
-			// make a copy so we lose reference to the underlying slice data
-			p.Bytes = make([]byte, 8*valueCount, 8*valueCount)
-			// collectd's protocol puts data in a seemingly weird
-			// order which appears to be exactly what we want.
-			copy(p.Bytes, partBytes[2+valueCount:2+valueCount+(valueCount*8)])
-			// todo: what if some data is missing?
-
-			p.DataTypes = make([]uint8, valueCount, valueCount)
-			err = binary.Read(partBuffer, binary.BigEndian, p.DataTypes)
-			if err != nil {
-				return nil, err
+		err := ps.parsePart(&st, offset, typ, partBytes, r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parsePart parses the contents of a part, other than a signature, updating
+// st and appending any packet or notification it completes to r.
+func (ps *Parser) parsePart(st *parseState, offset int, typ uint16, partBytes []byte, r *parseResult) error {
+	fail := func(err error, reason string) error {
+		return &ParseError{offset, typ, len(partBytes) + 4, reason, err}
+	}
+	p := &st.p
+
+	switch typ {
+	case partHost:
+		p.Hostname = r.strings.intern(partBytes[:len(partBytes)-1])
+	case partTime:
+		if len(partBytes) < 8 {
+			return fail(ErrorInvalid, "time is too short")
+		}
+		p.CdTime = binary.BigEndian.Uint64(partBytes) << 30
+	case partPlugin:
+		p.Plugin = r.strings.intern(partBytes[:len(partBytes)-1])
+	case partPluginInstance:
+		p.PluginInstance = r.strings.intern(partBytes[:len(partBytes)-1])
+	case partType:
+		p.Type = r.strings.intern(partBytes[:len(partBytes)-1])
+	case partTypeInstance:
+		p.TypeInstance = r.strings.intern(partBytes[:len(partBytes)-1])
+	case partValues:
+		if len(partBytes) < 2 {
+			return fail(ErrorInvalid, "value count is missing")
+		}
+		// each value has a one byte type and eight bytes of data
+		count := int(binary.BigEndian.Uint16(partBytes))
+		if len(partBytes) != 2+count*9 {
+			return fail(ErrorInvalid, fmt.Sprintf("part length does not match value count %d", count))
+		}
+
+		// make copies so we lose reference to the underlying slice data
+		p.DataTypes, p.Bytes = r.valueStorage(count)
+		copy(p.DataTypes, partBytes[2:2+count])
+		// collectd's protocol puts data in a seemingly weird
+		// order which appears to be exactly what we want.
+		copy(p.Bytes, partBytes[2+count:])
+
+		for i, t := range p.DataTypes {
+			if t > TypeAbsolute {
+				return fail(ErrorUnsupported, fmt.Sprintf("data source type %d is unknown", t))
 			}
-			for i, t := range p.DataTypes {
-				// derive/gauge is little endian in protocol (!?)
-				// reverse it so other code can be big endian
-				if t == TypeGauge {
-					for j, k := i*8, (i*8)+7; j < k; j, k = j+1, k-1 {
-						p.Bytes[j], p.Bytes[k] = p.Bytes[k], p.Bytes[j]
-					}
+			// derive/gauge is little endian in protocol (!?)
+			// reverse it so other code can be big endian
+			if t == TypeGauge {
+				for j, k := i*8, (i*8)+7; j < k; j, k = j+1, k-1 {
+					p.Bytes[j], p.Bytes[k] = p.Bytes[k], p.Bytes[j]
 				}
 			}
+		}
 
-			r = append(r, p)
-		case 7:
-			// interval
-			err = binary.Read(partBuffer, binary.BigEndian, &time)
-			if err != nil {
-				return nil, err
-			}
-			p.CdInterval = time << 30
-		case 8:
-			// high res time
-			err = binary.Read(partBuffer, binary.BigEndian, &p.CdTime)
-			if err != nil {
-				return nil, err
-			}
-		case 9:
-			// hi res interval
-			err = binary.Read(partBuffer, binary.BigEndian, &p.CdInterval)
-			if err != nil {
-				return nil, err
+		if ps.TypesDB != nil {
+			if err := ps.TypesDB.Validate(*p); err != nil {
+				r.invalid++
+				if ps.Invalid != nil {
+					ps.Invalid(err.(*ValidationError))
+				}
+				return nil
 			}
-		case 0x100:
-			// message (notifications), ignore
-		case 0x101:
-			// severity, ignore
-		case 0x200:
-			// Signature (HMAC-SHA-256), todo
-			return nil, ErrorUnsupported
-		case 0x210:
-			// Encryption (AES-256/OFB/SHA-1), todo
-			return nil, ErrorUnsupported
-		default:
-			return nil, ErrorUnsupported
+		}
+		r.packets = append(r.packets, *p)
+	case partInterval:
+		// interval
+		if len(partBytes) < 8 {
+			return fail(ErrorInvalid, "interval is too short")
+		}
+		p.CdInterval = binary.BigEndian.Uint64(partBytes) << 30
+	case partTimeHR:
+		// high res time
+		if len(partBytes) < 8 {
+			return fail(ErrorInvalid, "time is too short")
+		}
+		p.CdTime = binary.BigEndian.Uint64(partBytes)
+	case partIntervalHR:
+		// hi res interval
+		if len(partBytes) < 8 {
+			return fail(ErrorInvalid, "interval is too short")
+		}
+		p.CdInterval = binary.BigEndian.Uint64(partBytes)
+	case partMessage:
+		// message, which completes a notification
+		str := string(partBytes[:len(partBytes)-1])
+		n := Notification{
+			Hostname:       p.Hostname,
+			Plugin:         p.Plugin,
+			PluginInstance: p.PluginInstance,
+			Type:           p.Type,
+			TypeInstance:   p.TypeInstance,
+			CdTime:         p.CdTime,
+			Severity:       Severity(st.severity),
+			Message:        str,
+			Security:       p.Security,
+		}
+		// like collectd, ignore notifications with unknown severities
+		switch n.Severity {
+		case SeverityFailure, SeverityWarning, SeverityOkay:
+			r.notifications = append(r.notifications, n)
+		}
+	case partSeverity:
+		// severity
+		if len(partBytes) < 8 {
+			return fail(ErrorInvalid, "severity is too short")
+		}
+		st.severity = binary.BigEndian.Uint64(partBytes)
+	case partEncryption:
+		// Encryption (AES-256/OFB/SHA-1)
+		if ps.AuthDB == nil {
+			return fail(ErrorUnsupported, "encrypted data needs an AuthDB")
+		}
+		data, err := decrypt(ps.AuthDB, partBytes)
+		if err != nil {
+			return fail(err, "data could not be decrypted")
+		}
+		// the decrypted data is parsed from a clean state, but data
+		// after this part carries on as before. Offsets in the
+		// decrypted data don't mean anything in the datagram, so
+		// errors there are reported against this part.
+		err = ps.parse(data, 0, SecurityEncrypt, r)
+		if err != nil {
+			pe := err.(*ParseError)
+			reason := fmt.Sprintf("%s in part type 0x%04x in the encrypted data", pe.Reason, pe.PartType)
+			return fail(pe.Err, reason)
+		}
+	default:
+		if !ps.SkipUnknown {
+			return fail(ErrorUnsupported, "part type is unknown")
+		}
+		r.skipped++
+		if ps.Skipped != nil {
+			// make a copy so we lose reference to the underlying slice data
+			data := append([]byte(nil), partBytes...)
+			ps.Skipped(UnknownPart{offset, typ, data})
 		}
 	}
-	return &r, nil
+	return nil
 }
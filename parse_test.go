@@ -4,8 +4,8 @@
 package gocollectd
 
 import (
+	"errors"
 	"fmt"
-	"io"
 	"reflect"
 	"strconv"
 	"strings"
@@ -34,29 +34,31 @@ func h2b(s ...string) []byte {
 	return b
 }
 
+// unfortunately a real hexdump seems the best way to test this
+var testV5Data = []string{
+	"00 00 00 0f 6c 61 70 74 6f 70 2e 6c 61 6e 00", // hostname: "laptop.lan"
+	"00 08 00 0c 14 50 8f be 73 82 51 7e",          // time, hi res
+	"00 09 00 0c 00 00 00 02 80 00 00 00",          // interval, hi res
+	"00 02 00 0b 6d 65 6d 6f 72 79 00",             // plugin: memory
+	"00 05 00 0a 77 69 72 65 64 00",                // type instance: wired
+	"00 06 00 0f 00 01 01 00 00 00 00 00 43 cf 41", // value
+	"00 08 00 0c 14 50 8f be 73 82 94 9a",          // time, hi res
+	"00 02 00 0e 69 6e 74 65 72 66 61 63 65 00",    // plugin: interface
+	"00 03 00 08 6c 6f 30 00",                      // instance: lo0
+	"00 04 00 0e 69 66 5f 6f 63 74 65 74 73 00",    // type: if_octets
+	"00 05 00 05 00",                               // type instance: nil
+	"00 06 00 18 00 02 02 02 00 00 00 00 00 88 07 8b 00 00 00 00 00 88 07 8c", // 2 more values, note: the second one was manipulated to check order
+	"00 08 00 0c 14 50 8f be 73 84 40 6c",                                     // a new time
+	"00 04 00 0f 69 66 5f 70 61 63 6b 65 74 73 00",                            // plugin: ifpackets
+	"00 06 00 18 00 02 02 02 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00", // 2 more values
+}
+
 func TestParsev5(t *testing.T) {
-	// unfortunately a real hexdump seems the best way to test this
-	b := h2b(
-		"00 00 00 0f 6c 61 70 74 6f 70 2e 6c 61 6e 00", // hostname: "laptop.lan"
-		"00 08 00 0c 14 50 8f be 73 82 51 7e",          // time, hi res
-		"00 09 00 0c 00 00 00 02 80 00 00 00",          // interval, hi res
-		"00 02 00 0b 6d 65 6d 6f 72 79 00",             // plugin: memory
-		"00 05 00 0a 77 69 72 65 64 00",                // type instance: wired
-		"00 06 00 0f 00 01 01 00 00 00 00 00 43 cf 41", // value
-		"00 08 00 0c 14 50 8f be 73 82 94 9a",          // time, hi res
-		"00 02 00 0e 69 6e 74 65 72 66 61 63 65 00",    // plugin: interface
-		"00 03 00 08 6c 6f 30 00",                      // instance: lo0
-		"00 04 00 0e 69 66 5f 6f 63 74 65 74 73 00",    // type: if_octets
-		"00 05 00 05 00",                               // type instance: nil
-		"00 06 00 18 00 02 02 02 00 00 00 00 00 88 07 8b 00 00 00 00 00 88 07 8c", // 2 more values, note: the second one was manipulated to check order
-		"00 08 00 0c 14 50 8f be 73 84 40 6c",                                     // a new time
-		"00 04 00 0f 69 66 5f 70 61 63 6b 65 74 73 00",                            // plugin: ifpackets
-		"00 06 00 18 00 02 02 02 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00", // 2 more values
-	)
+	b := h2b(testV5Data...)
 	expected := []Packet{
-		{"laptop.lan", "memory", "", "", "wired", 1463827927039889790, 10737418240, []uint8{TypeGauge}, h2b("41 cf 43 00 00 00 00 00")},
-		{"laptop.lan", "interface", "lo0", "if_octets", "", 1463827927039906970, 10737418240, []uint8{TypeDerive, TypeDerive}, h2b("00 00 00 00 00 88 07 8b 00 00 00 00 00 88 07 8c")},
-		{"laptop.lan", "interface", "lo0", "if_packets", "", 1463827927040016492, 10737418240, []uint8{TypeDerive, TypeDerive}, h2b("00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00")},
+		{"laptop.lan", "memory", "", "", "wired", 1463827927039889790, 10737418240, []uint8{TypeGauge}, h2b("41 cf 43 00 00 00 00 00"), SecurityNone},
+		{"laptop.lan", "interface", "lo0", "if_octets", "", 1463827927039906970, 10737418240, []uint8{TypeDerive, TypeDerive}, h2b("00 00 00 00 00 88 07 8b 00 00 00 00 00 88 07 8c"), SecurityNone},
+		{"laptop.lan", "interface", "lo0", "if_packets", "", 1463827927040016492, 10737418240, []uint8{TypeDerive, TypeDerive}, h2b("00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00"), SecurityNone},
 	}
 	result, err := Parse(b)
 	if err != nil {
@@ -88,9 +90,9 @@ func TestParsev4(t *testing.T) {
 	)
 
 	expected := []Packet{
-		{"laptop.lan", "memory", "", "", "wired", 1463827926175711232, 10737418240, []uint8{TypeGauge}, h2b("41 cf 43 00 00 00 00 00")},
-		{"laptop.lan", "interface", "lo0", "if_octets", "", 1463827927249453056, 10737418240, []uint8{TypeDerive, TypeDerive}, h2b("00 00 00 00 00 88 07 8b 00 00 00 00 00 88 07 8c")},
-		{"laptop.lan", "interface", "lo0", "if_packets", "", 1463827927249453056, 10737418240, []uint8{TypeDerive, TypeDerive}, h2b("00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00")},
+		{"laptop.lan", "memory", "", "", "wired", 1463827926175711232, 10737418240, []uint8{TypeGauge}, h2b("41 cf 43 00 00 00 00 00"), SecurityNone},
+		{"laptop.lan", "interface", "lo0", "if_octets", "", 1463827927249453056, 10737418240, []uint8{TypeDerive, TypeDerive}, h2b("00 00 00 00 00 88 07 8b 00 00 00 00 00 88 07 8c"), SecurityNone},
+		{"laptop.lan", "interface", "lo0", "if_packets", "", 1463827927249453056, 10737418240, []uint8{TypeDerive, TypeDerive}, h2b("00 00 00 00 00 00 00 00 00 00 00 00 00 00 00 00"), SecurityNone},
 	}
 	result, err := Parse(b)
 	if err != nil {
@@ -112,7 +114,7 @@ func TestParseGauges(t *testing.T) {
 		"00 00 00 00 00 88 07 8c",                      // value3
 	)
 	expected := []Packet{
-		{"laptop.lan", "memory", "", "", "", 1463827927039889790, 10737418240, []uint8{TypeDerive, TypeGauge, TypeDerive}, h2b("00 00 00 00 00 88 07 8b 41 cf 43 00 00 00 00 00 00 00 00 00 00 88 07 8c")},
+		{"laptop.lan", "memory", "", "", "", 1463827927039889790, 10737418240, []uint8{TypeDerive, TypeGauge, TypeDerive}, h2b("00 00 00 00 00 88 07 8b 41 cf 43 00 00 00 00 00 00 00 00 00 00 88 07 8c"), SecurityNone},
 	}
 	result, err := Parse(b)
 	if err != nil {
@@ -131,32 +133,38 @@ func TestParseEmpty(t *testing.T) {
 	}
 }
 
+var errorTests = []struct {
+	name string
+	in   string
+	out  error
+}{
+	// these should never get sent
+	{"bad length packet", "00", ErrorInvalid},
+	{"bad length packet", "00 00 00 03", ErrorInvalid},
+	{"short packet", "00 00 00 04", ErrorInvalid},
+	{"not enough data packet", "00 00 00 05", ErrorInvalid},
+	{"value packet with missing data", "00 06 00 18 00 02 02 02 00 00 00 00 00 88 07 8b", ErrorInvalid},
+	{"value packet with too few values", "00 06 00 0f 00 02 01 00 00 00 00 00 43 cf 41", ErrorInvalid},
+	{"value packet with too many values", "00 06 00 0f 00 00 01 00 00 00 00 00 43 cf 41", ErrorInvalid},
+	{"value packet with huge count", "00 06 00 0f ff ff 01 00 00 00 00 00 43 cf 41", ErrorInvalid},
+	{"value packet without count", "00 06 00 05 00", ErrorInvalid},
+	{"value packet with unknown type", "00 06 00 0f 00 01 04 00 00 00 00 00 43 cf 41", ErrorUnsupported},
+	{"valid packet with extra data", "00 05 00 05 00 ff", ErrorInvalid},
+	{"valid packet with extra data", "00 05 00 05 00 ff ff", ErrorInvalid},
+	{"valid packet with extra data", "00 05 00 05 00 ff ff ff", ErrorInvalid},
+	{"valid packet with extra data", "00 05 00 05 00 ff ff ff ff", ErrorInvalid},
+
+	// note: real encrypted and signed packets have more data, but
+	// the protocol is undocumented so I've not made realistic tests
+	{"encrypted packet", "02 10 00 05 00", ErrorUnsupported},
+	{"signed packet", "02 00 00 05 00", ErrorUnsupported},
+	{"as-yet-undefined packet", "03 00 00 05 00", ErrorUnsupported},
+}
+
 func TestErrors(t *testing.T) {
-	tests := []struct {
-		name string
-		in   string
-		out  error
-	}{
-		// these should never get sent
-		{"bad length packet", "00", io.ErrUnexpectedEOF},
-		{"bad length packet", "00 00 00 03", ErrorInvalid},
-		{"short packet", "00 00 00 04", ErrorInvalid},
-		{"not enough data packet", "00 00 00 05", ErrorInvalid},
-		{"value packet with missing data", "00 06 00 18 00 02 02 02 00 00 00 00 00 88 07 8b", ErrorInvalid},
-		{"valid packet with extra data", "00 05 00 05 00 ff", io.ErrUnexpectedEOF},
-		{"valid packet with extra data", "00 05 00 05 00 ff ff", io.ErrUnexpectedEOF},
-		{"valid packet with extra data", "00 05 00 05 00 ff ff ff", io.ErrUnexpectedEOF},
-		{"valid packet with extra data", "00 05 00 05 00 ff ff ff ff", ErrorInvalid},
-
-		// note: real encrypted and signed packets have more data, but
-		// the protocol is undocumented so I've not made realistic tests
-		{"encrypted packet", "02 10 00 05 00", ErrorUnsupported},
-		{"signed packet", "02 00 00 05 00", ErrorUnsupported},
-		{"as-yet-undefined packet", "03 00 00 05 00", ErrorUnsupported},
-	}
-	for _, test := range tests {
+	for _, test := range errorTests {
 		result, err := Parse(h2b(test.in))
-		if err != test.out {
+		if !errors.Is(err, test.out) {
 			t.Errorf("%s: expected '%v', got '%v'", test.name, test.out, err)
 		}
 		if result != nil {
@@ -164,3 +172,181 @@ func TestErrors(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePartial(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       []string
+		packets  int
+		offset   int
+		partType uint16
+		err      error
+	}{
+		{"bad part", append(testUnsignedData[:3:3], "00 06 00 03"), 1, 41, partValues, ErrorInvalid},
+		{"unknown part", append(testUnsignedData[:3:3], "03 00 00 05 00"), 1, 41, 0x0300, ErrorUnsupported},
+		{"short header", append(testUnsignedData[:3:3], "00 06"), 1, 41, 0, ErrorInvalid},
+		{"bad part before values", append(testUnsignedData[:2:2], "00 06 00 03"), 0, 26, partValues, ErrorInvalid},
+		{"changed signed data", append(testSignedData[:len(testSignedData):len(testSignedData)], "00 06 00 03"), 0, 0, partSignature, ErrorSignature},
+	}
+	ps := Parser{AuthDB: Passwords{"admin": "secret"}}
+	for _, test := range tests {
+		r, err := ps.parseAll(h2b(test.in...))
+		if err == nil {
+			t.Fatalf("%s: expected an error", test.name)
+		}
+		if len(r.packets) != test.packets {
+			t.Errorf("%s: expected %d packets, got %d", test.name, test.packets, len(r.packets))
+		}
+		if err.Offset != test.offset || err.PartType != test.partType || err.Err != test.err {
+			t.Errorf("%s: expected '%v' in part type 0x%04x at offset %d, got '%v'", test.name, test.err, test.partType, test.offset, err)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	_, err := Parse(h2b(append(testUnsignedData[:3:3], "00 04 00 10 6d 65 6d 6f 72 79 00")...))
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected a *ParseError, got %T", err)
+	}
+	expected := ParseError{41, partType, 16, "part is longer than the remaining data", ErrorInvalid}
+	if *pe != expected {
+		t.Errorf("expected %+v, got %+v", expected, *pe)
+	}
+	if !errors.Is(err, ErrorInvalid) {
+		t.Errorf("expected error to match '%v'", ErrorInvalid)
+	}
+	msg := "Invalid collectd packet recieved: part is longer than the remaining data in part type 0x0004 with length 16 at offset 41"
+	if err.Error() != msg {
+		t.Errorf("expected %q, got %q", msg, err.Error())
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add(h2b(testV5Data...))
+	f.Add(h2b(testSignedData...))
+	f.Add(h2b(testEncryptedData...))
+	f.Add(h2b(testNotificationData...))
+	for _, test := range errorTests {
+		f.Add(h2b(test.in))
+	}
+	ps := Parser{AuthDB: Passwords{"admin": "secret"}}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		packets, notifications, err := ps.ParseAll(b)
+		if err != nil {
+			var pe *ParseError
+			if !errors.As(err, &pe) {
+				t.Fatalf("expected a *ParseError, got %T: %v", err, err)
+			}
+			if packets != nil || notifications != nil {
+				t.Fatalf("expected no packets with an error")
+			}
+			return
+		}
+		for _, p := range *packets {
+			if len(p.Bytes) != 8*len(p.DataTypes) {
+				t.Errorf("expected %d bytes for %d values, got %d", 8*len(p.DataTypes), len(p.DataTypes), len(p.Bytes))
+			}
+			for _, typ := range p.DataTypes {
+				if typ > TypeAbsolute {
+					t.Errorf("expected a known data type, got %d", typ)
+				}
+			}
+			// these must not panic
+			p.ValueNumbers()
+			p.Values()
+			p.Name()
+			p.ValueNames()
+		}
+	})
+}
+
+func TestParseSkipUnknown(t *testing.T) {
+	in := h2b(
+		"00 00 00 0f 6c 61 70 74 6f 70 2e 6c 61 6e 00", // hostname: "laptop.lan"
+		"00 02 00 0b 6d 65 6d 6f 72 79 00",             // plugin: memory
+		"03 00 00 07 aa bb cc",                         // as-yet-undefined part
+		"00 06 00 0f 00 01 01 00 00 00 00 00 43 cf 41", // value
+	)
+	_, err := Parse(in)
+	if !errors.Is(err, ErrorUnsupported) {
+		t.Errorf("expected '%v', got '%v'", ErrorUnsupported, err)
+	}
+
+	var skipped []UnknownPart
+	ps := Parser{SkipUnknown: true, Skipped: func(part UnknownPart) {
+		skipped = append(skipped, part)
+	}}
+	result, err := ps.Parse(in)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	expected := []Packet{
+		{"laptop.lan", "memory", "", "", "", 0, 0, []uint8{TypeGauge}, h2b("41 cf 43 00 00 00 00 00"), SecurityNone},
+	}
+	if !reflect.DeepEqual(*result, expected) {
+		t.Errorf("expected\n%v\ngot\n%v\n", expected, *result)
+	}
+	expectedSkipped := []UnknownPart{{26, 0x0300, h2b("aa bb cc")}}
+	if !reflect.DeepEqual(skipped, expectedSkipped) {
+		t.Errorf("expected %v, got %v", expectedSkipped, skipped)
+	}
+	// the data must be a copy
+	in[30] = 0
+	if skipped[0].Data[0] != 0xaa {
+		t.Errorf("expected skipped data to be copied")
+	}
+}
+
+func TestParseInto(t *testing.T) {
+	b := h2b(testV5Data...)
+	expected, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var packets []Packet
+	for i := 0; i < 2; i++ {
+		packets, err = ParseInto(packets[:0], b)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !reflect.DeepEqual(packets, *expected) {
+			t.Errorf("expected\n%v\ngot\n%v\n", *expected, packets)
+		}
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		packets, _ = ParseInto(packets[:0], b)
+	})
+	if allocs != 0 && !raceEnabled {
+		t.Errorf("expected no allocations, got %v", allocs)
+	}
+
+	packets, err = ParseInto(packets[:1], h2b("00 00 00 03"))
+	if !errors.Is(err, ErrorInvalid) {
+		t.Errorf("expected '%v', got '%v'", ErrorInvalid, err)
+	}
+	if len(packets) != 1 {
+		t.Errorf("expected dst to be returned unchanged, got %v", packets)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	data := h2b(testV5Data...)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		Parse(data)
+	}
+}
+
+func BenchmarkParseInto(b *testing.B) {
+	data := h2b(testV5Data...)
+	var packets []Packet
+	b.ReportAllocs()
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		packets, _ = ParseInto(packets[:0], data)
+	}
+}
@@ -0,0 +1,10 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+//go:build race
+
+package gocollectd
+
+// raceEnabled is set when testing with the race detector, which makes
+// sync.Pool drop items at random.
+const raceEnabled = true
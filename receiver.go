@@ -0,0 +1,247 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// DefaultQueueSize is the number of datagrams that can wait for a Server's
+// workers, unless the Server's QueueSize says otherwise. It is also used
+// when a Server has a DropPolicy other than Block but no QueueSize.
+const DefaultQueueSize = 1024
+
+// A DropPolicy decides what a Server does with a datagram when its queue is
+// full.
+type DropPolicy int
+
+const (
+	// Block waits for space in the queue. Nothing is dropped by the server,
+	// but the kernel drops datagrams if its socket buffers fill up while
+	// the server isn't reading.
+	Block DropPolicy = iota
+	// DropNewest drops the datagram that was just received.
+	DropNewest
+	// DropOldest drops the datagram that has been waiting longest, to make
+	// room for the one that was just received.
+	DropOldest
+)
+
+func (p DropPolicy) String() string {
+	switch p {
+	case Block:
+		return "Block"
+	case DropNewest:
+		return "DropNewest"
+	case DropOldest:
+		return "DropOldest"
+	}
+	return "Unknown"
+}
+
+// A receiver reads datagrams for a Server and passes them on to be handled,
+// either straight away or through a queue read by workers.
+type receiver struct {
+	s       *Server
+	size    int
+	pool    sync.Pool
+	queue   chan datagram
+	workers int
+}
+
+// A datagram is a received datagram waiting to be handled. buf came from the
+// receiver's pool, and is returned to it once the datagram has been handled.
+type datagram struct {
+	buf      *[]byte
+	n        int
+	addr     net.Addr
+	received time.Time
+}
+
+func newReceiver(s *Server, size int) *receiver {
+	r := &receiver{s: s, size: size}
+	r.pool.New = func() any {
+		// one extra byte means oversized datagrams can be spotted even if
+		// the kernel doesn't tell us they were truncated
+		b := make([]byte, size+1)
+		return &b
+	}
+	queueSize := s.QueueSize
+	if queueSize == 0 && (s.Workers > 0 || s.DropPolicy != Block) {
+		queueSize = DefaultQueueSize
+	}
+	if queueSize > 0 {
+		r.queue = make(chan datagram, queueSize)
+		// something has to empty the queue
+		r.workers = max(s.Workers, 1)
+	}
+	return r
+}
+
+// read reads datagrams from conn until it is closed.
+func (r *receiver) read(conn net.PacketConn) error {
+	if udp, ok := conn.(*net.UDPConn); ok && r.s.BatchSize > 1 {
+		return r.readBatches(udp)
+	}
+	for {
+		buf := r.pool.Get().(*[]byte)
+		n, addr, truncated, err := readDatagram(conn, *buf)
+		if err != nil {
+			r.pool.Put(buf)
+			if r.stopped(err) {
+				return err
+			}
+			continue
+		}
+		r.dispatch(datagram{buf, n, addr, time.Now()}, truncated)
+	}
+}
+
+// batchReader is implemented by ipv4.PacketConn and ipv6.PacketConn.
+type batchReader interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+// readBatches reads up to BatchSize datagrams at a time from conn until it is
+// closed.
+func (r *receiver) readBatches(conn *net.UDPConn) error {
+	var br batchReader
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		br = ipv4.NewPacketConn(conn)
+	} else {
+		br = ipv6.NewPacketConn(conn)
+	}
+
+	msgs := make([]ipv4.Message, r.s.BatchSize)
+	bufs := make([]*[]byte, len(msgs))
+	for i := range msgs {
+		bufs[i] = r.pool.Get().(*[]byte)
+		msgs[i].Buffers = [][]byte{*bufs[i]}
+	}
+	defer func() {
+		for _, buf := range bufs {
+			r.pool.Put(buf)
+		}
+	}()
+
+	for {
+		n, err := br.ReadBatch(msgs, 0)
+		if err != nil {
+			if r.stopped(err) {
+				return err
+			}
+			continue
+		}
+		received := time.Now()
+		for i := 0; i < n; i++ {
+			m := &msgs[i]
+			r.dispatch(datagram{bufs[i], m.N, m.Addr, received}, m.Flags&msgTrunc != 0)
+			bufs[i] = r.pool.Get().(*[]byte)
+			m.Buffers[0] = *bufs[i]
+		}
+	}
+}
+
+// readDatagram reads a datagram into buf, and reports whether the kernel
+// truncated it.
+func readDatagram(conn net.PacketConn, buf []byte) (int, net.Addr, bool, error) {
+	udp, ok := conn.(*net.UDPConn)
+	if !ok || msgTrunc == 0 {
+		n, addr, err := conn.ReadFrom(buf)
+		return n, addr, false, err
+	}
+	n, _, flags, addr, err := udp.ReadMsgUDP(buf, nil)
+	if err != nil {
+		return 0, nil, false, err
+	}
+	return n, addr, flags&msgTrunc != 0, nil
+}
+
+// stopped reports whether a read error means reading should stop. Other
+// errors are logged.
+func (r *receiver) stopped(err error) bool {
+	if r.s.shuttingDown() || errors.Is(err, net.ErrClosed) {
+		return true
+	}
+	r.s.logf("error: Failed to recieve packet %v", err)
+	return false
+}
+
+// dispatch drops a datagram if it was truncated, otherwise it is handled
+// straight away or queued for a worker.
+func (r *receiver) dispatch(d datagram, truncated bool) {
+	if truncated || d.n > r.size {
+		r.s.stats.datagrams.Add(1)
+		r.s.stats.truncated.Add(1)
+		r.s.logf("error: Failed to recieve packet from %v: %v", d.addr, ErrorTruncated)
+		r.pool.Put(d.buf)
+		return
+	}
+	if r.queue == nil {
+		r.handle(d)
+		return
+	}
+	r.enqueue(d)
+}
+
+// enqueue adds a datagram to the queue, following the server's DropPolicy
+// if the queue is full.
+func (r *receiver) enqueue(d datagram) {
+	switch r.s.DropPolicy {
+	case DropNewest:
+		select {
+		case r.queue <- d:
+		default:
+			r.drop(d)
+		}
+	case DropOldest:
+		for {
+			select {
+			case r.queue <- d:
+				return
+			default:
+			}
+			// a worker may have taken the oldest datagram already
+			select {
+			case old := <-r.queue:
+				r.drop(old)
+			default:
+			}
+		}
+	default:
+		r.queue <- d
+	}
+}
+
+// drop counts and discards a datagram that didn't fit in the queue.
+func (r *receiver) drop(d datagram) {
+	r.s.stats.datagrams.Add(1)
+	r.s.stats.queueFull.Add(1)
+	r.pool.Put(d.buf)
+}
+
+func (r *receiver) handle(d datagram) {
+	r.s.receive((*d.buf)[:d.n], d.addr, d.received)
+	r.pool.Put(d.buf)
+}
+
+// work handles queued datagrams until the queue is closed.
+func (r *receiver) work() {
+	for d := range r.queue {
+		r.handle(d)
+	}
+}
+
+// close closes the queue once nothing else will be added to it.
+func (r *receiver) close() {
+	if r.queue != nil {
+		close(r.queue)
+	}
+}
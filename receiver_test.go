@@ -0,0 +1,180 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// testDatagram returns a datagram from r's pool containing n.
+func testDatagram(r *receiver, n byte) datagram {
+	buf := r.pool.Get().(*[]byte)
+	(*buf)[0] = n
+	return datagram{buf, 1, nil, time.Now()}
+}
+
+func TestReceiverDropPolicy(t *testing.T) {
+	tests := []struct {
+		policy   DropPolicy
+		expected []byte
+	}{
+		{DropNewest, []byte{1, 2}},
+		{DropOldest, []byte{3, 4}},
+	}
+	for _, test := range tests {
+		s := &Server{QueueSize: 2, DropPolicy: test.policy}
+		r := newReceiver(s, DefaultPacketSize)
+		for i := byte(1); i <= 4; i++ {
+			r.dispatch(testDatagram(r, i), false)
+		}
+		r.close()
+
+		var queued []byte
+		for d := range r.queue {
+			queued = append(queued, (*d.buf)[0])
+		}
+		if string(queued) != string(test.expected) {
+			t.Errorf("%v: expected %v to be queued, got %v", test.policy, test.expected, queued)
+		}
+		expected := Stats{Datagrams: 2, DroppedQueueFull: 2}
+		if s.Stats() != expected {
+			t.Errorf("%v: expected stats %+v, got %+v", test.policy, expected, s.Stats())
+		}
+	}
+}
+
+func TestReceiverQueueSize(t *testing.T) {
+	tests := []struct {
+		s         *Server
+		queueSize int
+		workers   int
+	}{
+		{&Server{}, 0, 0},
+		{&Server{DropPolicy: DropNewest}, DefaultQueueSize, 1},
+		{&Server{DropPolicy: DropOldest}, DefaultQueueSize, 1},
+		{&Server{DropPolicy: DropNewest, QueueSize: 10}, 10, 1},
+		{&Server{Workers: 4}, DefaultQueueSize, 4},
+		{&Server{QueueSize: 10}, 10, 1},
+	}
+	for _, test := range tests {
+		r := newReceiver(test.s, DefaultPacketSize)
+		if cap(r.queue) != test.queueSize || r.workers != test.workers {
+			t.Errorf("%v, QueueSize %d, Workers %d: expected a queue of %d with %d workers, got %d with %d",
+				test.s.DropPolicy, test.s.QueueSize, test.s.Workers, test.queueSize, test.workers, cap(r.queue), r.workers)
+		}
+	}
+}
+
+func TestReceiverBlock(t *testing.T) {
+	s := &Server{QueueSize: 1}
+	r := newReceiver(s, DefaultPacketSize)
+	r.dispatch(testDatagram(r, 1), false)
+
+	done := make(chan bool)
+	go func() {
+		r.dispatch(testDatagram(r, 2), false)
+		done <- true
+	}()
+	select {
+	case <-done:
+		t.Fatalf("expected dispatch to block while the queue is full")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	<-r.queue
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected dispatch to finish once there was space")
+	}
+	if s.Stats() != (Stats{}) {
+		t.Errorf("expected nothing to be dropped, got %+v", s.Stats())
+	}
+}
+
+func TestServerQueue(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan bool, 5)
+	release := make(chan bool)
+	c := make(chan Packet, 10)
+	s := Server{
+		QueueSize:  2,
+		DropPolicy: DropNewest,
+		Handler: HandlerFunc(func(r *Request) {
+			started <- true
+			<-release
+			c <- r.Packet
+		}),
+	}
+	go s.Serve(conn)
+	defer s.Shutdown(context.Background())
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write(h2b(testUnsignedData...))
+	<-started
+	// one datagram is being handled and two are queued, so two are dropped
+	for i := 0; i < 4; i++ {
+		client.Write(h2b(testUnsignedData...))
+	}
+	deadline := time.Now().Add(time.Second)
+	for s.Stats().DroppedQueueFull < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			t.Fatalf("expected a packet")
+		}
+	}
+	expected := Stats{Datagrams: 5, Packets: 3, DroppedQueueFull: 2}
+	if s.Stats() != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, s.Stats())
+	}
+}
+
+func TestServerNotificationDropPolicy(t *testing.T) {
+	tests := []struct {
+		policy   DropPolicy
+		expected string
+		stats    Stats
+	}{
+		{DropNewest, "12", Stats{Datagrams: 4, Notifications: 2, DroppedNotifications: 2}},
+		{DropOldest, "34", Stats{Datagrams: 4, Notifications: 4, DroppedNotifications: 2}},
+	}
+	for _, test := range tests {
+		s := &Server{Notifications: make(chan Notification, 2), DropPolicy: test.policy}
+		for _, m := range "1234" {
+			b, err := EncodeNotifications([]Notification{{Hostname: "laptop.lan", Plugin: "ping", Severity: SeverityWarning, Message: string(m)}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.receive(b, nil, time.Now())
+		}
+		close(s.Notifications)
+
+		var received string
+		for n := range s.Notifications {
+			received += n.Message
+		}
+		if received != test.expected {
+			t.Errorf("%v: expected %s to be received, got %s", test.policy, test.expected, received)
+		}
+		if s.Stats() != test.stats {
+			t.Errorf("%v: expected stats %+v, got %+v", test.policy, test.stats, s.Stats())
+		}
+	}
+}
@@ -0,0 +1,374 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A RenameMatch selects packets by their identifier fields. Each field is
+// either empty, which matches anything, a glob such as "eth*", a regular
+// expression between slashes such as "/^sd([a-z]+)$/", or a string that
+// must match exactly.
+type RenameMatch struct {
+	Host           string `json:"host,omitempty"`
+	Plugin         string `json:"plugin,omitempty"`
+	PluginInstance string `json:"plugin_instance,omitempty"`
+	Type           string `json:"type,omitempty"`
+	TypeInstance   string `json:"type_instance,omitempty"`
+}
+
+// A RenameRule names the values of the packets it matches.
+//
+// Name and Tags are templates. {host}, {plugin}, {plugin_instance}, {type},
+// {type_instance} and {ds} are replaced by the packet's fields and the data
+// source name of each value. Regular expression captures can be used too:
+// named captures by their name, and numbered captures as {1}, {2} and so
+// on, counting the captures of each field in the order above.
+type RenameRule struct {
+	Match RenameMatch       `json:"match"`
+	Name  string            `json:"name"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+// A RenameConfig is the format of the file read by LoadRenamer:
+//
+//	{
+//	  "rules": [
+//	    {
+//	      "match": {"plugin": "disk", "plugin_instance": "/^sd([a-z]+)$/"},
+//	      "name": "disk.{type}.{ds}",
+//	      "tags": {"host": "{host}", "device": "{1}"}
+//	    }
+//	  ]
+//	}
+type RenameConfig struct {
+	Rules []RenameRule `json:"rules"`
+}
+
+// A Metric is a value with the name and tags given to it by a Renamer.
+type Metric struct {
+	Name  string
+	Tags  map[string]string
+	Value Value
+}
+
+// A Renamer is a Namer that names packets using the first RenameRule that
+// matches them.
+type Renamer struct {
+	// Default names packets that don't match a rule. If it is nil
+	// LegacyNamer is used.
+	Default Namer
+
+	// TypesDB holds the data source names used by {ds}. If it is nil the
+	// default TypesDB is used.
+	TypesDB TypesDB
+
+	rules []renameRule
+}
+
+// renameRule is a compiled RenameRule.
+type renameRule struct {
+	fields [5]fieldMatcher
+	name   template
+	tags   map[string]template
+	// appendDS is set if the name doesn't use {ds}, so values of packets with
+	// more than one need it added to be told apart.
+	appendDS bool
+}
+
+// renameFields are the names of the fields a RenameMatch matches, in order.
+var renameFields = [5]string{"host", "plugin", "plugin_instance", "type", "type_instance"}
+
+// renameValues returns the values of renameFields for p.
+func renameValues(p Packet) [5]string {
+	return [5]string{p.Hostname, p.Plugin, p.PluginInstance, p.Type, p.TypeInstance}
+}
+
+// NewRenamer compiles rename rules. Rules are tried in the order they are
+// given.
+func NewRenamer(rules []RenameRule) (*Renamer, error) {
+	r := &Renamer{}
+	for i, rule := range rules {
+		compiled, err := compileRenameRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rename rule %d: %v", i+1, err)
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+// ParseRenamer reads rename rules in the format of a RenameConfig.
+func ParseRenamer(rd io.Reader) (*Renamer, error) {
+	var config RenameConfig
+	d := json.NewDecoder(rd)
+	d.DisallowUnknownFields()
+	if err := d.Decode(&config); err != nil {
+		return nil, err
+	}
+	return NewRenamer(config.Rules)
+}
+
+// LoadRenamer reads rename rules from the file at path.
+func LoadRenamer(path string) (*Renamer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := ParseRenamer(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return r, nil
+}
+
+func compileRenameRule(rule RenameRule) (renameRule, error) {
+	var compiled renameRule
+	refs := map[string]bool{"ds": true}
+	for _, f := range renameFields {
+		refs[f] = true
+	}
+	patterns := [5]string{rule.Match.Host, rule.Match.Plugin, rule.Match.PluginInstance, rule.Match.Type, rule.Match.TypeInstance}
+	captures := 0
+	for i, pattern := range patterns {
+		m, err := compileFieldMatcher(pattern)
+		if err != nil {
+			return compiled, fmt.Errorf("%s: %v", renameFields[i], err)
+		}
+		if m.re != nil {
+			for _, name := range m.re.SubexpNames()[1:] {
+				captures++
+				refs[strconv.Itoa(captures)] = true
+				if name != "" {
+					refs[name] = true
+				}
+			}
+		}
+		compiled.fields[i] = m
+	}
+
+	if rule.Name == "" {
+		return compiled, fmt.Errorf("name is empty")
+	}
+	var err error
+	compiled.name, err = parseTemplate(rule.Name, refs)
+	if err != nil {
+		return compiled, fmt.Errorf("name: %v", err)
+	}
+	compiled.appendDS = !compiled.name.uses("ds")
+	for k, v := range rule.Tags {
+		t, err := parseTemplate(v, refs)
+		if err != nil {
+			return compiled, fmt.Errorf("tag %q: %v", k, err)
+		}
+		if compiled.tags == nil {
+			compiled.tags = map[string]template{}
+		}
+		compiled.tags[k] = t
+	}
+	return compiled, nil
+}
+
+// A fieldMatcher matches one field of a packet.
+type fieldMatcher struct {
+	pattern string
+	glob    bool
+	re      *regexp.Regexp
+}
+
+func compileFieldMatcher(pattern string) (fieldMatcher, error) {
+	m := fieldMatcher{pattern: pattern}
+	switch {
+	case len(pattern) >= 2 && pattern[0] == '/' && pattern[len(pattern)-1] == '/':
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return m, err
+		}
+		m.re = re
+	case strings.ContainsAny(pattern, "*?["):
+		if _, err := path.Match(pattern, ""); err != nil {
+			return m, fmt.Errorf("%q: %v", pattern, err)
+		}
+		m.glob = true
+	}
+	return m, nil
+}
+
+// match reports whether s matches, and returns any regular expression
+// captures.
+func (m fieldMatcher) match(s string) (bool, []string) {
+	switch {
+	case m.re != nil:
+		captures := m.re.FindStringSubmatch(s)
+		if captures == nil {
+			return false, nil
+		}
+		return true, captures[1:]
+	case m.glob:
+		ok, _ := path.Match(m.pattern, s)
+		return ok, nil
+	case m.pattern == "":
+		return true, nil
+	}
+	return m.pattern == s, nil
+}
+
+// A template is a name or tag with {references} to packet fields and
+// captures. Odd elements are references, even elements literal text.
+type template []string
+
+func parseTemplate(s string, refs map[string]bool) (template, error) {
+	var t template
+	for {
+		start := strings.IndexByte(s, '{')
+		if start < 0 {
+			return append(t, s), nil
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated reference in %q", s)
+		}
+		ref := s[start+1 : start+end]
+		if !refs[ref] {
+			return nil, fmt.Errorf("unknown reference {%s}", ref)
+		}
+		t = append(t, s[:start], ref)
+		s = s[start+end+1:]
+	}
+}
+
+// uses reports whether the template refers to ref.
+func (t template) uses(ref string) bool {
+	for i := 1; i < len(t); i += 2 {
+		if t[i] == ref {
+			return true
+		}
+	}
+	return false
+}
+
+func (t template) render(vars map[string]string) string {
+	var b strings.Builder
+	for i, s := range t {
+		if i%2 == 0 {
+			b.WriteString(s)
+		} else {
+			b.WriteString(vars[s])
+		}
+	}
+	return b.String()
+}
+
+// match returns the index of the rule that matches p and its template
+// variables, or -1 if no rule matches.
+func (r *Renamer) match(p Packet) (int, map[string]string) {
+	values := renameValues(p)
+rules:
+	for i, rule := range r.rules {
+		var captures [][]string
+		for j, m := range rule.fields {
+			ok, c := m.match(values[j])
+			if !ok {
+				continue rules
+			}
+			captures = append(captures, c)
+		}
+
+		vars := map[string]string{}
+		for j, f := range renameFields {
+			vars[f] = values[j]
+		}
+		n := 0
+		for j, c := range captures {
+			if len(c) == 0 {
+				continue
+			}
+			names := rule.fields[j].re.SubexpNames()[1:]
+			for k, v := range c {
+				n++
+				vars[strconv.Itoa(n)] = v
+				if names[k] != "" {
+					vars[names[k]] = v
+				}
+			}
+		}
+		return i, vars
+	}
+	return -1, nil
+}
+
+// Match returns the index of the first rule that matches p, or -1 if no rule
+// matches.
+func (r *Renamer) Match(p Packet) int {
+	i, _ := r.match(p)
+	return i
+}
+
+// Name returns the name of the packet, with {ds} left empty.
+func (r *Renamer) Name(p Packet) string {
+	i, vars := r.match(p)
+	if i < 0 {
+		return r.defaultNamer().Name(p)
+	}
+	return r.rules[i].name.render(vars)
+}
+
+// ValueNames returns the name of each value. If a rule's name doesn't use
+// {ds}, and the packet has more than one value, each name ends with a dot
+// and the value's data source name.
+func (r *Renamer) ValueNames(p Packet) []string {
+	metrics := r.Rename(p)
+	names := make([]string, len(metrics))
+	for i, m := range metrics {
+		names[i] = m.Name
+	}
+	return names
+}
+
+// Rename returns the name, tags and value of each value in p. Values of
+// packets that don't match a rule are named by Default and have no tags.
+func (r *Renamer) Rename(p Packet) []Metric {
+	values := p.Values()
+	metrics := make([]Metric, len(values))
+	i, vars := r.match(p)
+	if i < 0 {
+		for j, name := range r.defaultNamer().ValueNames(p) {
+			metrics[j] = Metric{Name: name, Value: values[j]}
+		}
+		return metrics
+	}
+
+	rule := r.rules[i]
+	for j, ds := range dataSourceNames(r.TypesDB, p) {
+		vars["ds"] = ds
+		m := Metric{Name: rule.name.render(vars), Value: values[j]}
+		if rule.appendDS && len(values) > 1 {
+			m.Name += "." + ds
+		}
+		if rule.tags != nil {
+			m.Tags = make(map[string]string, len(rule.tags))
+			for k, t := range rule.tags {
+				m.Tags[k] = t.render(vars)
+			}
+		}
+		metrics[j] = m
+	}
+	return metrics
+}
+
+func (r *Renamer) defaultNamer() Namer {
+	if r.Default != nil {
+		return r.Default
+	}
+	return LegacyNamer{}
+}
@@ -0,0 +1,118 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testRenameConfig = `{
+  "rules": [
+    {
+      "match": {"plugin": "interface", "plugin_instance": "lo*"},
+      "name": "net.loopback.{type}"
+    },
+    {
+      "match": {"plugin": "df", "plugin_instance": "/^(?P<mount>.+)$/", "type_instance": "/^(used|free)$/"},
+      "name": "disk.{2}",
+      "tags": {"host": "{host}", "mount": "{mount}"}
+    },
+    {
+      "match": {"host": "*.lan", "plugin": "memory"},
+      "name": "{host}.mem.{type_instance}.{ds}",
+      "tags": {"type": "{type}"}
+    }
+  ]
+}`
+
+func TestRenamer(t *testing.T) {
+	r, err := ParseRenamer(strings.NewReader(testRenameConfig))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	reserved := testNamerDF
+	reserved.TypeInstance = "reserved"
+
+	tests := []struct {
+		packet Packet
+		rule   int
+		names  []string
+		tags   map[string]string
+	}{
+		{testNamerInterface, 0, []string{"net.loopback.if_octets.rx", "net.loopback.if_octets.tx"}, nil},
+		{testNamerDF, 1, []string{"disk.used"}, map[string]string{"host": "laptop.lan", "mount": "root"}},
+		{testNamerMemory, 2, []string{"laptop.lan.mem.wired.value"}, map[string]string{"type": "memory"}},
+		{reserved, -1, []string{"df_root_reserved"}, nil},
+	}
+	for _, test := range tests {
+		id := test.packet.Identifier()
+		if rule := r.Match(test.packet); rule != test.rule {
+			t.Errorf("%s: expected rule %d, got %d", id, test.rule, rule)
+		}
+		if names := r.ValueNames(test.packet); !reflect.DeepEqual(names, test.names) {
+			t.Errorf("%s: expected %q, got %q", id, test.names, names)
+		}
+		metrics := r.Rename(test.packet)
+		for i, m := range metrics {
+			if !reflect.DeepEqual(m.Tags, test.tags) {
+				t.Errorf("%s: expected tags %v, got %v", id, test.tags, m.Tags)
+			}
+			if !reflect.DeepEqual(m.Value, test.packet.Values()[i]) {
+				t.Errorf("%s: expected value %v, got %v", id, test.packet.Values()[i], m.Value)
+			}
+		}
+	}
+
+	if name := r.Name(testNamerMemory); name != "laptop.lan.mem.wired." {
+		t.Errorf("expected {ds} to be empty, got %q", name)
+	}
+	r.Default = IdentifierNamer{}
+	if names := r.ValueNames(reserved); !reflect.DeepEqual(names, []string{"laptop.lan/df-root/df_complex-reserved:value"}) {
+		t.Errorf("expected the default namer to be used, got %q", names)
+	}
+}
+
+func TestRenamerErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{"bad json", `{"rules": [`, "unexpected EOF"},
+		{"unknown field", `{"rules": [{"match": {"instance": "x"}, "name": "x"}]}`, `unknown field "instance"`},
+		{"empty name", `{"rules": [{"match": {"plugin": "x"}}]}`, "rename rule 1: name is empty"},
+		{"bad regexp", `{"rules": [{"match": {"type": "/(/"}, "name": "x"}]}`, "rename rule 1: type: error parsing regexp"},
+		{"bad glob", `{"rules": [{"match": {"host": "["}, "name": "x"}]}`, "rename rule 1: host: \"[\": syntax error in pattern"},
+		{"unknown reference", `{"rules": [{"name": "x"}, {"match": {"plugin": "/(a)/"}, "name": "{2}"}]}`, "rename rule 2: name: unknown reference {2}"},
+		{"unterminated", `{"rules": [{"name": "x", "tags": {"a": "{host"}}]}`, `rename rule 1: tag "a": unterminated reference in "{host"`},
+	}
+	for _, test := range tests {
+		_, err := ParseRenamer(strings.NewReader(test.in))
+		if err == nil || !strings.Contains(err.Error(), test.out) {
+			t.Errorf("%s: expected %q, got %v", test.name, test.out, err)
+		}
+	}
+}
+
+func TestLoadRenamer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rename.json")
+	os.WriteFile(path, []byte(testRenameConfig), 0644)
+	r, err := LoadRenamer(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if rule := r.Match(testNamerDF); rule != 1 {
+		t.Errorf("expected rule 1, got %d", rule)
+	}
+
+	os.WriteFile(path, []byte("{"), 0644)
+	_, err = LoadRenamer(path)
+	if err == nil || !strings.HasPrefix(err.Error(), path) {
+		t.Errorf("expected an error naming the file, got %v", err)
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+//go:build !unix || solaris
+
+package gocollectd
+
+import (
+	"errors"
+	"syscall"
+)
+
+// reusePort fails on platforms without SO_REUSEPORT, so a Server can only use
+// one socket.
+func reusePort(network, address string, c syscall.RawConn) error {
+	return errors.New("collectd Sockets requires SO_REUSEPORT, which is not supported on this platform")
+}
@@ -0,0 +1,185 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// The error returned if signed data does not match its signature
+var ErrorSignature = errors.New("Invalid collectd packet signature")
+
+// The error returned if encrypted data can not be decrypted
+var ErrorDecrypt = errors.New("Failed to decrypt collectd packet")
+
+// The error returned if signed or encrypted data is sent by a user that is
+// not known
+var ErrorUnknownUser = errors.New("Unknown collectd user")
+
+// SecurityLevel describes how collectd data was protected when it was sent.
+// It mirrors collectd's SecurityLevel option.
+type SecurityLevel int
+
+const (
+	SecurityNone SecurityLevel = iota
+	SecuritySign
+	SecurityEncrypt
+)
+
+// String returns the name collectd uses for this security level.
+func (l SecurityLevel) String() string {
+	switch l {
+	case SecurityNone:
+		return "None"
+	case SecuritySign:
+		return "Sign"
+	case SecurityEncrypt:
+		return "Encrypt"
+	}
+	return "Unknown"
+}
+
+// An AuthDB looks up the passwords used to sign and encrypt collectd data.
+type AuthDB interface {
+	// Password returns the password for user, or false if the user is not
+	// known.
+	Password(user string) (string, bool)
+}
+
+// Passwords is an AuthDB that maps usernames to passwords.
+type Passwords map[string]string
+
+// Password returns the password for user.
+func (p Passwords) Password(user string) (string, bool) {
+	password, ok := p[user]
+	return password, ok
+}
+
+// verifySignature checks a signature part against the data that follows it.
+// collectd signs the username and everything after the signature part with
+// HMAC-SHA-256, using the user's password as the key.
+func verifySignature(db AuthDB, part []byte, rest []byte) error {
+	if len(part) <= sha256.Size {
+		return ErrorInvalid
+	}
+	hash, user := part[:sha256.Size], part[sha256.Size:]
+
+	password, ok := db.Password(string(user))
+	if !ok {
+		return ErrorUnknownUser
+	}
+
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write(user)
+	mac.Write(rest)
+	if !hmac.Equal(mac.Sum(nil), hash) {
+		return ErrorSignature
+	}
+	return nil
+}
+
+// decrypt decrypts an encrypted part and returns the data inside it.
+//
+// An encrypted part contains the length of the username, the username, an
+// IV, and then the encrypted data. The data is encrypted using AES-256 in
+// OFB mode, with the SHA-256 hash of the user's password as the key. The
+// first 20 bytes of the decrypted data are a SHA-1 checksum of the rest.
+func decrypt(db AuthDB, part []byte) ([]byte, error) {
+	if len(part) < 2 {
+		return nil, ErrorInvalid
+	}
+	userLength := int(binary.BigEndian.Uint16(part))
+	if userLength == 0 || len(part) <= 2+userLength+aes.BlockSize+sha1.Size {
+		return nil, ErrorInvalid
+	}
+	user := part[2 : 2+userLength]
+	iv := part[2+userLength : 2+userLength+aes.BlockSize]
+	encrypted := part[2+userLength+aes.BlockSize:]
+
+	password, ok := db.Password(string(user))
+	if !ok {
+		return nil, ErrorUnknownUser
+	}
+
+	key := sha256.Sum256([]byte(password))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	decrypted := make([]byte, len(encrypted))
+	cipher.NewOFB(block, iv).XORKeyStream(decrypted, encrypted)
+
+	hash, data := decrypted[:sha1.Size], decrypted[sha1.Size:]
+	checksum := sha1.Sum(data)
+	if !bytes.Equal(checksum[:], hash) {
+		return nil, ErrorDecrypt
+	}
+	return data, nil
+}
+
+// signatureOverhead returns the number of bytes sign adds to data.
+func signatureOverhead(user string) int {
+	return 4 + sha256.Size + len(user)
+}
+
+// sign prepends a signature part to data.
+func sign(user, password string, data []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(password))
+	mac.Write([]byte(user))
+	mac.Write(data)
+
+	b := make([]byte, 0, signatureOverhead(user)+len(data))
+	b = binary.BigEndian.AppendUint16(b, partSignature)
+	b = binary.BigEndian.AppendUint16(b, uint16(signatureOverhead(user)))
+	b = mac.Sum(b)
+	b = append(b, user...)
+	return append(b, data...)
+}
+
+// encryptionOverhead returns the number of bytes encrypt adds to data.
+func encryptionOverhead(user string) int {
+	return 6 + len(user) + aes.BlockSize + sha1.Size
+}
+
+// encrypt wraps data in an encrypted part. See decrypt for details of the
+// format.
+func encrypt(user, password string, data []byte) ([]byte, error) {
+	length := encryptionOverhead(user) + len(data)
+	if len(user) == 0 || length > math.MaxUint16 {
+		return nil, ErrorUnencodable
+	}
+
+	b := make([]byte, 0, length)
+	b = binary.BigEndian.AppendUint16(b, partEncryption)
+	b = binary.BigEndian.AppendUint16(b, uint16(length))
+	b = binary.BigEndian.AppendUint16(b, uint16(len(user)))
+	b = append(b, user...)
+	iv := b[len(b) : len(b)+aes.BlockSize]
+	_, err := rand.Read(iv)
+	if err != nil {
+		return nil, err
+	}
+	b = b[:len(b)+aes.BlockSize]
+
+	checksum := sha1.Sum(data)
+	plain := append(checksum[:], data...)
+
+	key := sha256.Sum256([]byte(password))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	encrypted := b[len(b):length]
+	cipher.NewOFB(block, iv).XORKeyStream(encrypted, plain)
+	return b[:length], nil
+}
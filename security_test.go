@@ -0,0 +1,122 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testSignedData = []string{
+	"02 00 00 29", // signature, HMAC-SHA-256 of "admin" and the rest of the data with password "secret"
+	"a7 d0 65 01 d4 46 04 b2 df 3a 73 6e cf dc ae cc 9c 5f 88 22 b8 b6 57 c3 ba 99 32 60 f7 73 f1 d8",
+	"61 64 6d 69 6e", // username: "admin"
+	"00 00 00 0f 6c 61 70 74 6f 70 2e 6c 61 6e 00", // hostname: "laptop.lan"
+	"00 08 00 0c 14 50 8f be 73 82 51 7e",          // time, hi res
+	"00 09 00 0c 00 00 00 02 80 00 00 00",          // interval, hi res
+	"00 02 00 0b 6d 65 6d 6f 72 79 00",             // plugin: memory
+	"00 05 00 0a 77 69 72 65 64 00",                // type instance: wired
+	"00 06 00 0f 00 01 01 00 00 00 00 00 43 cf 41", // value
+}
+
+func TestParseSigned(t *testing.T) {
+	ps := Parser{AuthDB: Passwords{"admin": "secret"}}
+	expected := []Packet{
+		{"laptop.lan", "memory", "", "", "wired", 1463827927039889790, 10737418240, []uint8{TypeGauge}, h2b("41 cf 43 00 00 00 00 00"), SecuritySign},
+	}
+	result, err := ps.Parse(h2b(testSignedData...))
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	} else if !reflect.DeepEqual(*result, expected) {
+		t.Errorf("expected\n%v\ngot\n%v\n", expected, *result)
+	}
+}
+
+func TestParseSignedErrors(t *testing.T) {
+	tampered := h2b(testSignedData...)
+	tampered[len(tampered)-1] = 0x42
+
+	tests := []struct {
+		name string
+		db   AuthDB
+		in   []byte
+		out  error
+	}{
+		{"no auth db", nil, h2b(testSignedData...), ErrorUnsupported},
+		{"wrong password", Passwords{"admin": "wrong"}, h2b(testSignedData...), ErrorSignature},
+		{"unknown user", Passwords{"other": "secret"}, h2b(testSignedData...), ErrorUnknownUser},
+		{"tampered data", Passwords{"admin": "secret"}, tampered, ErrorSignature},
+		{"missing username", Passwords{"admin": "secret"}, h2b("02 00 00 24", testSignedData[1]), ErrorInvalid},
+	}
+	for _, test := range tests {
+		ps := Parser{AuthDB: test.db}
+		result, err := ps.Parse(test.in)
+		if !errors.Is(err, test.out) {
+			t.Errorf("%s: expected '%v', got '%v'", test.name, test.out, err)
+		}
+		if result != nil {
+			t.Errorf("%s: expected nil got %v", test.name, *result)
+		}
+	}
+}
+
+var testEncryptedData = []string{
+	"02 10 00 7a",          // encrypted with password "secret"
+	"00 05 61 64 6d 69 6e", // username: "admin"
+	"00 01 02 03 04 05 06 07 08 09 0a 0b 0c 0d 0e 0f", // iv
+	"4f b9 de a4 17 07 eb 39 3d 27 25 f2 8c a5 a8 98 c6 04 1b 81 80 5a c9 3c",
+	"07 66 47 23 96 0e a9 4b f1 60 e3 cd a4 ce e6 1e a2 66 35 d4 c2 2d d4 e2",
+	"bd a6 70 1f 1a c2 59 43 cf bf 2a 75 a5 f9 df 4f 50 8f 5d fd d8 38 b2 f7",
+	"9c f8 d9 15 63 16 69 01 21 8a cf 76 8d 58 13 61 a7 8b e4 f2 ed bd 81",
+}
+
+func TestParseEncrypted(t *testing.T) {
+	ps := Parser{AuthDB: Passwords{"admin": "secret"}}
+	b := h2b(testEncryptedData...)
+	// unencrypted data after an encrypted part is parsed as normal
+	b = append(b, h2b(
+		"00 02 00 0b 6d 65 6d 6f 72 79 00",             // plugin: memory
+		"00 06 00 0f 00 01 01 00 00 00 00 00 43 cf 41", // value
+	)...)
+	expected := []Packet{
+		{"laptop.lan", "memory", "", "", "wired", 1463827927039889790, 10737418240, []uint8{TypeGauge}, h2b("41 cf 43 00 00 00 00 00"), SecurityEncrypt},
+		{"", "memory", "", "", "", 0, 0, []uint8{TypeGauge}, h2b("41 cf 43 00 00 00 00 00"), SecurityNone},
+	}
+	result, err := ps.Parse(b)
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	} else if !reflect.DeepEqual(*result, expected) {
+		t.Errorf("expected\n%v\ngot\n%v\n", expected, *result)
+	}
+}
+
+func TestParseEncryptedErrors(t *testing.T) {
+	tampered := h2b(testEncryptedData...)
+	tampered[len(tampered)-1] = 0x42
+
+	tests := []struct {
+		name string
+		db   AuthDB
+		in   []byte
+		out  error
+	}{
+		{"no auth db", nil, h2b(testEncryptedData...), ErrorUnsupported},
+		{"wrong password", Passwords{"admin": "wrong"}, h2b(testEncryptedData...), ErrorDecrypt},
+		{"unknown user", Passwords{"other": "secret"}, h2b(testEncryptedData...), ErrorUnknownUser},
+		{"tampered data", Passwords{"admin": "secret"}, tampered, ErrorDecrypt},
+		{"empty username", Passwords{"admin": "secret"}, h2b("02 10 00 31 00 00", testEncryptedData[2], testEncryptedData[3]), ErrorInvalid},
+		{"long username", Passwords{"admin": "secret"}, h2b("02 10 00 31 00 ff", testEncryptedData[2], testEncryptedData[3]), ErrorInvalid},
+	}
+	for _, test := range tests {
+		ps := Parser{AuthDB: test.db}
+		result, err := ps.Parse(test.in)
+		if !errors.Is(err, test.out) {
+			t.Errorf("%s: expected '%v', got '%v'", test.name, test.out, err)
+		}
+		if result != nil {
+			t.Errorf("%s: expected nil got %v", test.name, *result)
+		}
+	}
+}
@@ -4,34 +4,513 @@
 package gocollectd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math"
 	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
+// The error returned by a Server's Serve and ListenAndServe methods after it
+// has been shut down
+var ErrorServerClosed = errors.New("Server closed")
+
+// The error logged when a datagram larger than a Server's MaxPacketSize is
+// received
+var ErrorTruncated = errors.New("Truncated collectd packet recieved")
+
 // Listen creates a UDP server that parses collectd data into packets and
-// sends them over a channel.
+// sends them over a channel. It never returns, and exits the program if it
+// fails to listen; use a Server to handle errors or to stop listening.
 func Listen(addr string, c chan Packet) {
-	laddr, err := net.ResolveUDPAddr("udp", addr)
+	s := Server{Addr: addr, Handler: ChanHandler(c)}
+	err := s.ListenAndServe(context.Background())
+	log.Fatalln("fatal: failed to listen", err)
+}
+
+// A Server receives collectd data over UDP.
+type Server struct {
+	// Addr is the UDP address to listen on. If it is a multicast address,
+	// such as "239.192.74.66:25826", the server joins the multicast group
+	// and only receives datagrams sent to it, unless Groups is also set.
+	Addr string
+
+	// Groups are more multicast groups to join, such as DefaultIPv4Group or
+	// DefaultIPv6Group. Datagrams sent to any address on Addr's port are
+	// received.
+	Groups []string
+
+	// Interface is the name of the network interface used to join multicast
+	// groups, like collectd's Interface option. If it is empty the system
+	// chooses an interface.
+	Interface string
+
+	// MaxPacketSize is the size of the largest datagram this server can
+	// receive, like collectd's MaxPacketSize option. Larger datagrams are
+	// dropped. If it is zero DefaultPacketSize is used.
+	MaxPacketSize int
+
+	// Sockets is the number of sockets ListenAndServe opens. If it is more
+	// than one, each socket uses SO_REUSEPORT so the kernel shares datagrams
+	// between them.
+	Sockets int
+
+	// BatchSize is the number of datagrams read from a socket at once. On
+	// Linux each batch is read with a single recvmmsg system call.
+	BatchSize int
+
+	// Workers is the number of goroutines that parse and handle datagrams.
+	// If it is zero, datagrams are handled by the goroutine that read them,
+	// or by a single worker if there is a queue.
+	// The Handler must be safe for concurrent use if Sockets or Workers is
+	// more than one.
+	Workers int
+
+	// QueueSize is the number of datagrams that can wait to be handled. If
+	// it is zero, DefaultQueueSize is used when there are Workers or the
+	// DropPolicy isn't Block, otherwise datagrams are handled as soon as
+	// they are read.
+	QueueSize int
+
+	// DropPolicy decides what happens to datagrams when the queue is full.
+	// Any policy other than Block means there is always a queue, so a slow
+	// Handler never stops the server reading. Dropped datagrams are counted
+	// in Stats().DroppedQueueFull.
+	DropPolicy DropPolicy
+
+	// SecurityLevel is the minimum security level of the data this server
+	// accepts. Like collectd's SecurityLevel option, a server using
+	// SecuritySign accepts signed or encrypted data, and a server using
+	// SecurityEncrypt only accepts encrypted data. Anything else is dropped.
+	SecurityLevel SecurityLevel
+
+	// Parser is used to parse received data. If it is nil the zero Parser
+	// is used, which means signed and encrypted data is dropped.
+	Parser *Parser
+
+	// Handler handles the packets this server receives. If it is nil
+	// packets are dropped.
+	Handler Handler
+
+	// Notifications receives the notifications this server receives. If it
+	// is nil notifications are dropped. When the channel is full the
+	// DropPolicy decides what happens, so with Block it should be buffered
+	// and always read from. Dropped notifications are counted in
+	// Stats().DroppedNotifications.
+	Notifications chan Notification
+
+	// ErrorLog logs errors receiving data. If it is nil the log package's
+	// standard logger is used.
+	ErrorLog *log.Logger
+
+	// ErrorHandler is called for each datagram that could not be completely
+	// parsed. If it is nil the error is logged to ErrorLog.
+	ErrorHandler func(e *ReceiveError)
+
+	stats serverStats
+
+	mu       sync.Mutex
+	conns    map[net.PacketConn]struct{}
+	serving  sync.WaitGroup
+	shutdown bool
+}
+
+// Stats counts the data a Server has received and dropped. Datagrams that
+// can only be partly parsed are counted as dropped, although the packets
+// before the part that failed are still handled.
+type Stats struct {
+	// Datagrams is the number of UDP datagrams received.
+	Datagrams uint64
+	// Packets is the number of packets passed to the Handler.
+	Packets uint64
+	// Notifications is the number of notifications sent on to the
+	// Notifications channel.
+	Notifications uint64
+
+	// DroppedInsecure is the number of packets and notifications dropped
+	// because they were less secure than the server's SecurityLevel.
+	DroppedInsecure uint64
+	// DroppedUnknownUser is the number of datagrams dropped because they
+	// were signed or encrypted by an unknown user.
+	DroppedUnknownUser uint64
+	// DroppedSignature is the number of datagrams dropped because their
+	// signature was invalid.
+	DroppedSignature uint64
+	// DroppedDecrypt is the number of datagrams dropped because they could
+	// not be decrypted.
+	DroppedDecrypt uint64
+	// DroppedUnsupported is the number of datagrams dropped because they
+	// contained unsupported data.
+	DroppedUnsupported uint64
+	// DroppedInvalid is the number of datagrams dropped because they could
+	// not be parsed.
+	DroppedInvalid uint64
+	// DroppedTruncated is the number of datagrams dropped because they were
+	// larger than MaxPacketSize.
+	DroppedTruncated uint64
+	// DroppedQueueFull is the number of datagrams dropped because the queue
+	// was full.
+	DroppedQueueFull uint64
+	// DroppedValidation is the number of packets dropped because they did
+	// not match the Parser's TypesDB.
+	DroppedValidation uint64
+	// DroppedNotifications is the number of notifications dropped because
+	// the Notifications channel was full. With DropOldest these were
+	// already counted in Notifications.
+	DroppedNotifications uint64
+
+	// SkippedUnknown is the number of parts skipped because the Parser uses
+	// SkipUnknown and didn't know their type.
+	SkippedUnknown uint64
+}
+
+type serverStats struct {
+	datagrams     atomic.Uint64
+	packets       atomic.Uint64
+	notifications atomic.Uint64
+	insecure      atomic.Uint64
+	unknownUser   atomic.Uint64
+	signature     atomic.Uint64
+	decrypt       atomic.Uint64
+	unsupported   atomic.Uint64
+	invalid       atomic.Uint64
+	truncated     atomic.Uint64
+	queueFull     atomic.Uint64
+	skipped       atomic.Uint64
+	validation    atomic.Uint64
+	notifyFull    atomic.Uint64
+}
+
+// Stats returns the number of datagrams and packets this server has received
+// and dropped.
+func (s *Server) Stats() Stats {
+	return Stats{
+		Datagrams:            s.stats.datagrams.Load(),
+		Packets:              s.stats.packets.Load(),
+		Notifications:        s.stats.notifications.Load(),
+		DroppedInsecure:      s.stats.insecure.Load(),
+		DroppedUnknownUser:   s.stats.unknownUser.Load(),
+		DroppedSignature:     s.stats.signature.Load(),
+		DroppedDecrypt:       s.stats.decrypt.Load(),
+		DroppedUnsupported:   s.stats.unsupported.Load(),
+		DroppedInvalid:       s.stats.invalid.Load(),
+		DroppedTruncated:     s.stats.truncated.Load(),
+		DroppedQueueFull:     s.stats.queueFull.Load(),
+		SkippedUnknown:       s.stats.skipped.Load(),
+		DroppedValidation:    s.stats.validation.Load(),
+		DroppedNotifications: s.stats.notifyFull.Load(),
+	}
+}
+
+// ListenAndServe listens on s.Addr, joins any multicast groups and then calls
+// Serve. It returns when listening fails, ctx is cancelled or Shutdown is
+// called.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	network, addr, groups, err := s.listenConfig()
 	if err != nil {
-		log.Fatalln("fatal: failed to resolve address", err)
+		return err
 	}
-	conn, err := net.ListenUDP("udp", laddr)
+	conns, err := s.listen(network, addr)
 	if err != nil {
-		log.Fatalln("fatal: failed to listen", err)
+		return err
 	}
-	for {
-		buf := make([]byte, 1452)
-		n, err := conn.Read(buf[:])
+	for _, conn := range conns {
+		err = s.joinGroups(conn, groups)
 		if err != nil {
-			log.Println("error: Failed to recieve packet", err)
-		} else {
-			packets, err := Parse(buf[0:n])
+			closeAll(conns)
+			return err
+		}
+	}
+	stop := context.AfterFunc(ctx, func() {
+		closeAll(conns)
+	})
+	defer stop()
+
+	err = s.serve(conns)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// listen opens the server's sockets. If there is more than one they all use
+// SO_REUSEPORT.
+func (s *Server) listen(network, addr string) ([]net.PacketConn, error) {
+	var control func(network, address string, c syscall.RawConn) error
+	if s.Sockets > 1 {
+		control = reusePort
+	}
+	conns := make([]net.PacketConn, 0, max(s.Sockets, 1))
+	for i := 0; i < cap(conns); i++ {
+		conn, err := listenPacket(network, addr, control)
+		if err != nil {
+			closeAll(conns)
+			return nil, err
+		}
+		conns = append(conns, conn)
+		// if the port was chosen by the system, the rest need to use it too
+		addr = conn.LocalAddr().String()
+	}
+	return conns, nil
+}
+
+// listenPacket listens on addr, which may be a multicast group.
+func listenPacket(network, addr string, control func(network, address string, c syscall.RawConn) error) (net.PacketConn, error) {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.IsMulticast() {
+			udpAddr, err := net.ResolveUDPAddr(network, addr)
 			if err != nil {
-				log.Println("error: Failed to recieve packet", err)
+				return nil, err
+			}
+			return listenGroup(network, udpAddr, control)
+		}
+	}
+	lc := net.ListenConfig{Control: control}
+	return lc.ListenPacket(context.Background(), network, addr)
+}
+
+func closeAll(conns []net.PacketConn) {
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// Serve reads collectd data from conn, parses it into packets and
+// notifications and passes them on to the server's Handler and Notifications
+// channel. It returns ErrorServerClosed after Shutdown is called, or an error
+// if conn can not be read. conn is closed when Serve returns.
+func (s *Server) Serve(conn net.PacketConn) error {
+	return s.serve([]net.PacketConn{conn})
+}
+
+// serve reads from each connection until they are all closed, or one of them
+// fails.
+func (s *Server) serve(conns []net.PacketConn) error {
+	defer closeAll(conns)
+	if s.SecurityLevel > SecurityNone && (s.Parser == nil || s.Parser.AuthDB == nil) {
+		return errors.New("collectd SecurityLevel requires an AuthDB")
+	}
+	size := s.MaxPacketSize
+	if size == 0 {
+		size = DefaultPacketSize
+	}
+	if size < 0 || size > math.MaxUint16 {
+		return errors.New("collectd MaxPacketSize must be between 1 and 65535")
+	}
+	if s.QueueSize < 0 {
+		return errors.New("collectd QueueSize must not be negative")
+	}
+	if !s.track(conns) {
+		return ErrorServerClosed
+	}
+	defer s.untrack(conns)
+
+	r := newReceiver(s, size)
+	var workers sync.WaitGroup
+	for i := 0; i < r.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			r.work()
+		}()
+	}
+
+	errs := make(chan error, len(conns))
+	for _, conn := range conns {
+		go func() {
+			errs <- r.read(conn)
+		}()
+	}
+	// when the first reader stops, stop the rest
+	err := <-errs
+	closeAll(conns)
+	for i := 1; i < len(conns); i++ {
+		<-errs
+	}
+	r.close()
+	workers.Wait()
+
+	if s.shuttingDown() {
+		return ErrorServerClosed
+	}
+	return err
+}
+
+// Shutdown stops the server, closing all of its connections and waiting for
+// any packets being received to be handled. If ctx is cancelled first, it
+// returns the context's error.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shutdown = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.serving.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// track records that conns are being served, unless the server has been shut
+// down.
+func (s *Server) track(conns []net.PacketConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.shutdown {
+		return false
+	}
+	if s.conns == nil {
+		s.conns = make(map[net.PacketConn]struct{})
+	}
+	for _, conn := range conns {
+		s.conns[conn] = struct{}{}
+	}
+	s.serving.Add(1)
+	return true
+}
+
+// untrack records that conns are no longer being served.
+func (s *Server) untrack(conns []net.PacketConn) {
+	s.mu.Lock()
+	for _, conn := range conns {
+		delete(s.conns, conn)
+	}
+	s.mu.Unlock()
+	s.serving.Done()
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.shutdown
+}
+
+func (s *Server) logf(format string, v ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+// A ReceiveError describes a datagram a Server could not completely parse.
+type ReceiveError struct {
+	// Addr is the address the datagram was sent from.
+	Addr net.Addr
+
+	// ParseError describes the part of the datagram that could not be
+	// parsed.
+	*ParseError
+}
+
+func (e *ReceiveError) Error() string {
+	return fmt.Sprintf("collectd datagram from %v: %v", e.Addr, e.ParseError)
+}
+
+func (e *ReceiveError) Unwrap() error {
+	return e.ParseError
+}
+
+// receive parses a datagram and passes on the packets and notifications in it
+// that are secure enough. If only part of the datagram can be parsed,
+// everything before the part that failed is passed on.
+func (s *Server) receive(b []byte, addr net.Addr, received time.Time) {
+	s.stats.datagrams.Add(1)
+
+	parser := s.Parser
+	if parser == nil {
+		parser = &Parser{}
+	}
+	r, perr := parser.parseAll(b)
+	s.stats.skipped.Add(uint64(r.skipped))
+	s.stats.validation.Add(uint64(r.invalid))
+	if perr != nil {
+		switch perr.Err {
+		case ErrorUnknownUser:
+			s.stats.unknownUser.Add(1)
+		case ErrorSignature:
+			s.stats.signature.Add(1)
+		case ErrorDecrypt:
+			s.stats.decrypt.Add(1)
+		case ErrorUnsupported:
+			s.stats.unsupported.Add(1)
+		default:
+			s.stats.invalid.Add(1)
+		}
+		e := &ReceiveError{Addr: addr, ParseError: perr}
+		if s.ErrorHandler != nil {
+			s.ErrorHandler(e)
+		} else {
+			s.logf("error: Failed to recieve packet %v", e)
+		}
+	}
+
+	for _, p := range r.packets {
+		if p.Security < s.SecurityLevel {
+			s.stats.insecure.Add(1)
+			continue
+		}
+		if s.Handler == nil {
+			continue
+		}
+		s.stats.packets.Add(1)
+		s.Handler.ServeCollectd(&Request{Packet: p, Addr: addr, Received: received})
+	}
+
+	for _, n := range r.notifications {
+		if n.Security < s.SecurityLevel {
+			s.stats.insecure.Add(1)
+			continue
+		}
+		if s.Notifications == nil {
+			continue
+		}
+		s.notify(n)
+	}
+}
+
+// notify sends a notification on the Notifications channel, following the
+// server's DropPolicy if the channel is full.
+func (s *Server) notify(n Notification) {
+	switch s.DropPolicy {
+	case DropNewest:
+		select {
+		case s.Notifications <- n:
+		default:
+			s.stats.notifyFull.Add(1)
+			return
+		}
+	case DropOldest:
+		for sent := false; !sent; {
+			select {
+			case s.Notifications <- n:
+				sent = true
+				continue
+			default:
 			}
-			for _, p := range *packets {
-				c <- p
+			// the reader may have taken the oldest notification already
+			select {
+			case <-s.Notifications:
+				s.stats.notifyFull.Add(1)
+			default:
 			}
 		}
+	default:
+		s.Notifications <- n
 	}
+	s.stats.notifications.Add(1)
 }
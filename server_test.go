@@ -0,0 +1,480 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var testUnsignedData = []string{
+	"00 00 00 0f 6c 61 70 74 6f 70 2e 6c 61 6e 00", // hostname: "laptop.lan"
+	"00 02 00 0b 6d 65 6d 6f 72 79 00",             // plugin: memory
+	"00 06 00 0f 00 01 01 00 00 00 00 00 43 cf 41", // value
+}
+
+func TestServerSecurityLevel(t *testing.T) {
+	tests := []struct {
+		level    SecurityLevel
+		in       []string
+		received int
+		stats    Stats
+	}{
+		{SecurityNone, testUnsignedData, 1, Stats{Datagrams: 1, Packets: 1}},
+		{SecurityNone, testSignedData, 1, Stats{Datagrams: 1, Packets: 1}},
+		{SecurityNone, testEncryptedData, 1, Stats{Datagrams: 1, Packets: 1}},
+		{SecuritySign, testUnsignedData, 0, Stats{Datagrams: 1, DroppedInsecure: 1}},
+		{SecuritySign, testSignedData, 1, Stats{Datagrams: 1, Packets: 1}},
+		{SecuritySign, testEncryptedData, 1, Stats{Datagrams: 1, Packets: 1}},
+		{SecurityEncrypt, testUnsignedData, 0, Stats{Datagrams: 1, DroppedInsecure: 1}},
+		{SecurityEncrypt, testSignedData, 0, Stats{Datagrams: 1, DroppedInsecure: 1}},
+		{SecurityEncrypt, testEncryptedData, 1, Stats{Datagrams: 1, Packets: 1}},
+	}
+	for i, test := range tests {
+		s := Server{
+			SecurityLevel: test.level,
+			Parser:        &Parser{AuthDB: Passwords{"admin": "secret"}},
+		}
+		c := make(chan Packet, 10)
+		s.Handler = ChanHandler(c)
+		s.receive(h2b(test.in...), nil, time.Now())
+		if len(c) != test.received {
+			t.Errorf("%d: expected %d packets, got %d", i, test.received, len(c))
+		}
+		if s.Stats() != test.stats {
+			t.Errorf("%d: expected stats %+v, got %+v", i, test.stats, s.Stats())
+		}
+	}
+}
+
+func TestServerDropped(t *testing.T) {
+	tests := []struct {
+		db    AuthDB
+		in    []byte
+		stats Stats
+	}{
+		{nil, h2b(testSignedData...), Stats{Datagrams: 1, DroppedUnsupported: 1}},
+		{Passwords{"other": "secret"}, h2b(testSignedData...), Stats{Datagrams: 1, DroppedUnknownUser: 1}},
+		{Passwords{"admin": "wrong"}, h2b(testSignedData...), Stats{Datagrams: 1, DroppedSignature: 1}},
+		{Passwords{"admin": "wrong"}, h2b(testEncryptedData...), Stats{Datagrams: 1, DroppedDecrypt: 1}},
+		{nil, h2b("00 00 00 03"), Stats{Datagrams: 1, DroppedInvalid: 1}},
+	}
+	for i, test := range tests {
+		c := make(chan Packet, 10)
+		s := Server{
+			Parser:   &Parser{AuthDB: test.db},
+			Handler:  ChanHandler(c),
+			ErrorLog: log.New(io.Discard, "", 0),
+		}
+		s.receive(test.in, nil, time.Now())
+		if len(c) != 0 {
+			t.Errorf("%d: expected no packets, got %d", i, len(c))
+		}
+		if s.Stats() != test.stats {
+			t.Errorf("%d: expected stats %+v, got %+v", i, test.stats, s.Stats())
+		}
+	}
+}
+
+func TestServerRequiresAuthDB(t *testing.T) {
+	s := Server{Addr: "127.0.0.1:0", SecurityLevel: SecuritySign}
+	err := s.ListenAndServe(context.Background())
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestServerNotifications(t *testing.T) {
+	s := Server{Notifications: make(chan Notification, 10)}
+	s.receive(h2b(testNotificationData...), nil, time.Now())
+	if len(s.Notifications) != 1 {
+		t.Errorf("expected 1 notification, got %d", len(s.Notifications))
+	}
+	expected := Stats{Datagrams: 1, Notifications: 1}
+	if s.Stats() != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, s.Stats())
+	}
+
+	s = Server{
+		SecurityLevel: SecuritySign,
+		Parser:        &Parser{AuthDB: Passwords{}},
+		Notifications: make(chan Notification, 10),
+	}
+	s.receive(h2b(testNotificationData...), nil, time.Now())
+	if len(s.Notifications) != 0 {
+		t.Errorf("expected no notifications, got %d", len(s.Notifications))
+	}
+	expected = Stats{Datagrams: 1, DroppedInsecure: 1}
+	if s.Stats() != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, s.Stats())
+	}
+}
+
+func TestServerServe(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := make(chan *Request)
+	s := Server{Handler: HandlerFunc(func(r *Request) {
+		requests <- r
+	})}
+	served := make(chan error)
+	go func() {
+		served <- s.Serve(conn)
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.Write(h2b(testUnsignedData...))
+
+	select {
+	case r := <-requests:
+		if r.Packet.Plugin != "memory" {
+			t.Errorf("expected memory, got %v", r.Packet.Plugin)
+		}
+		if r.Addr.String() != client.LocalAddr().String() {
+			t.Errorf("expected %v, got %v", client.LocalAddr(), r.Addr)
+		}
+		if time.Since(r.Received) > time.Second {
+			t.Errorf("expected current time, got %v", r.Received)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected a packet")
+	}
+
+	err = s.Shutdown(context.Background())
+	if err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	select {
+	case err := <-served:
+		if err != ErrorServerClosed {
+			t.Errorf("expected '%v', got '%v'", ErrorServerClosed, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected Serve to return")
+	}
+
+	err = s.Serve(conn)
+	if err != ErrorServerClosed {
+		t.Errorf("expected '%v', got '%v'", ErrorServerClosed, err)
+	}
+}
+
+func TestServerListenAndServeCancel(t *testing.T) {
+	s := Server{Addr: "127.0.0.1:0"}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() {
+		served <- s.ListenAndServe(ctx)
+	}()
+	cancel()
+
+	select {
+	case err := <-served:
+		if err != context.Canceled {
+			t.Errorf("expected '%v', got '%v'", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected ListenAndServe to return")
+	}
+}
+
+func TestServerListenAndServeError(t *testing.T) {
+	s := Server{Addr: "not an address"}
+	err := s.ListenAndServe(context.Background())
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestServerErrorLog(t *testing.T) {
+	var buf strings.Builder
+	s := Server{ErrorLog: log.New(&buf, "", 0)}
+	s.receive(h2b("00 00 00 03"), nil, time.Now())
+	if !strings.Contains(buf.String(), ErrorInvalid.Error()) {
+		t.Errorf("expected error to be logged, got %q", buf.String())
+	}
+}
+
+func TestServerErrorHandler(t *testing.T) {
+	var errs []*ReceiveError
+	c := make(chan Packet, 10)
+	s := Server{
+		Handler: ChanHandler(c),
+		ErrorHandler: func(e *ReceiveError) {
+			errs = append(errs, e)
+		},
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 25826}
+	s.receive(h2b(append(testUnsignedData[:3:3], "00 06 00 03")...), addr, time.Now())
+
+	// the packet before the bad part is still handled
+	if len(c) != 1 {
+		t.Errorf("expected 1 packet, got %d", len(c))
+	}
+	expected := Stats{Datagrams: 1, Packets: 1, DroppedInvalid: 1}
+	if s.Stats() != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, s.Stats())
+	}
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errs))
+	}
+	e := errs[0]
+	if e.Addr != addr || e.Offset != 41 || e.PartType != partValues || e.Err != ErrorInvalid {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if !errors.Is(e, ErrorInvalid) {
+		t.Errorf("expected error to match '%v'", ErrorInvalid)
+	}
+}
+
+func TestServerSkippedUnknown(t *testing.T) {
+	c := make(chan Packet, 10)
+	s := Server{Parser: &Parser{SkipUnknown: true}, Handler: ChanHandler(c)}
+	s.receive(h2b(append([]string{"03 00 00 05 00"}, testUnsignedData...)...), nil, time.Now())
+	if len(c) != 1 {
+		t.Errorf("expected 1 packet, got %d", len(c))
+	}
+	expected := Stats{Datagrams: 1, Packets: 1, SkippedUnknown: 1}
+	if s.Stats() != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, s.Stats())
+	}
+}
+
+func TestServerDroppedValidation(t *testing.T) {
+	c := make(chan Packet, 10)
+	db := TypesDB{"": {{"value", TypeGauge, 0, 1}}}
+	s := Server{Parser: &Parser{TypesDB: db}, Handler: ChanHandler(c)}
+	s.receive(h2b(testUnsignedData...), nil, time.Now())
+	if len(c) != 0 {
+		t.Errorf("expected no packets, got %d", len(c))
+	}
+	expected := Stats{Datagrams: 1, DroppedValidation: 1}
+	if s.Stats() != expected {
+		t.Errorf("expected stats %+v, got %+v", expected, s.Stats())
+	}
+}
+
+// packetConn hides the type of a net.PacketConn.
+type packetConn struct {
+	net.PacketConn
+}
+
+func TestServerMaxPacketSize(t *testing.T) {
+	tests := map[string]struct {
+		wrap      func(net.PacketConn) net.PacketConn
+		batchSize int
+	}{
+		"udp":     {func(c net.PacketConn) net.PacketConn { return c }, 0},
+		"generic": {func(c net.PacketConn) net.PacketConn { return packetConn{c} }, 0},
+		"batch":   {func(c net.PacketConn) net.PacketConn { return c }, 8},
+	}
+	for name, test := range tests {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := make(chan Packet)
+		s := Server{
+			MaxPacketSize: 50,
+			BatchSize:     test.batchSize,
+			Handler:       ChanHandler(c),
+			ErrorLog:      log.New(io.Discard, "", 0),
+		}
+		go s.Serve(test.wrap(conn))
+
+		client, err := net.Dial("udp", conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.Write(h2b(testSignedData...))
+		client.Write(h2b(testUnsignedData...))
+
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected a packet", name)
+		}
+		expected := Stats{Datagrams: 2, Packets: 1, DroppedTruncated: 1}
+		if s.Stats() != expected {
+			t.Errorf("%s: expected stats %+v, got %+v", name, expected, s.Stats())
+		}
+		client.Close()
+		s.Shutdown(context.Background())
+	}
+}
+
+func TestServerInvalidMaxPacketSize(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Server{MaxPacketSize: 65536}
+	err = s.Serve(conn)
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+// freePort returns a local UDP address that is not in use.
+func freePort(t testing.TB) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+// sendDatagrams sends n copies of b to addr from each of several clients. If
+// received is not nil, clients wait while more than window datagrams are in
+// flight, so the server's socket buffers don't overflow.
+func sendDatagrams(t testing.TB, addr string, clients, n int, b []byte, received *atomic.Uint64, window uint64) {
+	var sent atomic.Uint64
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		client, err := net.Dial("udp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n; j++ {
+				// give up waiting if datagrams are being lost
+				deadline := time.Now().Add(10 * time.Millisecond)
+				for received != nil && sent.Load() > received.Load()+window && time.Now().Before(deadline) {
+					runtime.Gosched()
+				}
+				client.Write(b)
+				sent.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// waitForPackets waits for the counter to reach n, or for nothing to arrive for
+// a while.
+func waitForPackets(counter *atomic.Uint64, n uint64) uint64 {
+	last := counter.Load()
+	for last < n {
+		time.Sleep(50 * time.Millisecond)
+		current := counter.Load()
+		if current == last {
+			break
+		}
+		last = current
+	}
+	return last
+}
+
+func TestServerConcurrent(t *testing.T) {
+	tests := []struct {
+		sockets, batchSize, workers int
+	}{
+		{1, 0, 4},
+		{1, 8, 0},
+		{1, 8, 4},
+		{4, 0, 0},
+		{4, 8, 4},
+	}
+	for _, test := range tests {
+		name := fmt.Sprintf("sockets=%d,batch=%d,workers=%d", test.sockets, test.batchSize, test.workers)
+		s := &Server{
+			Addr:      freePort(t),
+			Sockets:   test.sockets,
+			BatchSize: test.batchSize,
+			Workers:   test.workers,
+		}
+		var received atomic.Uint64
+		s.Handler = HandlerFunc(func(r *Request) {
+			received.Add(1)
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error)
+		go func() {
+			served <- s.ListenAndServe(ctx)
+		}()
+		// give the server time to start listening
+		time.Sleep(10 * time.Millisecond)
+
+		sendDatagrams(t, s.Addr, 8, 25, h2b(testUnsignedData...), nil, 0)
+		n := waitForPackets(&received, 200)
+		if n != 200 {
+			t.Errorf("%s: expected 200 packets, got %d", name, n)
+		}
+		if s.Stats().Packets != n {
+			t.Errorf("%s: expected stats to count %d packets, got %+v", name, n, s.Stats())
+		}
+
+		cancel()
+		select {
+		case err := <-served:
+			if err != context.Canceled {
+				t.Errorf("%s: expected '%v', got '%v'", name, context.Canceled, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: expected ListenAndServe to return", name)
+		}
+	}
+}
+
+func BenchmarkServer(b *testing.B) {
+	benchmarks := []struct {
+		sockets, batchSize, workers int
+	}{
+		{1, 0, 0},
+		{1, 64, 0},
+		{4, 64, 4},
+	}
+	for _, bm := range benchmarks {
+		name := fmt.Sprintf("sockets=%d,batch=%d,workers=%d", bm.sockets, bm.batchSize, bm.workers)
+		b.Run(name, func(b *testing.B) {
+			s := &Server{
+				Addr:      freePort(b),
+				Sockets:   bm.sockets,
+				BatchSize: bm.batchSize,
+				Workers:   bm.workers,
+			}
+			var received atomic.Uint64
+			var last atomic.Int64
+			s.Handler = HandlerFunc(func(r *Request) {
+				received.Add(1)
+				last.Store(time.Now().UnixNano())
+			})
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go s.ListenAndServe(ctx)
+			time.Sleep(10 * time.Millisecond)
+
+			data := h2b(testUnsignedData...)
+			clients := 8
+			b.ResetTimer()
+			start := time.Now()
+			sendDatagrams(b, s.Addr, clients, b.N/clients+1, data, &received, 256)
+			n := waitForPackets(&received, uint64(clients*(b.N/clients+1)))
+			b.StopTimer()
+			elapsed := time.Unix(0, last.Load()).Sub(start)
+
+			b.ReportMetric(float64(n)/elapsed.Seconds(), "datagrams/s")
+		})
+	}
+}
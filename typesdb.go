@@ -0,0 +1,315 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The error returned if a packet's type is not in a TypesDB
+var ErrorUnknownType = errors.New("Unknown collectd type")
+
+// The error returned if a packet's values don't match the data sources of its
+// type
+var ErrorDataSources = errors.New("collectd values do not match their type's data sources")
+
+// The error returned if a gauge value is outside the range of its data source
+var ErrorOutOfRange = errors.New("collectd value is outside its data source's range")
+
+// A ValidationError describes a packet that doesn't match its type in a
+// TypesDB.
+type ValidationError struct {
+	// Identifier identifies the packet's values, as returned by
+	// Packet.Identifier.
+	Identifier string
+	// Reason describes what was wrong with the values.
+	Reason string
+	// Err is ErrorUnknownType, ErrorDataSources or ErrorOutOfRange.
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v: %s for %s", e.Err, e.Reason, e.Identifier)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// A DataSource describes one of the values of a collectd type.
+type DataSource struct {
+	Name string
+	// Type is TypeCounter, TypeGauge, TypeDerive or TypeAbsolute.
+	Type uint8
+	// Min and Max are the range of valid values. They are NaN if there is
+	// no limit.
+	Min float64
+	Max float64
+}
+
+// A TypesDB holds the data sources of each collectd type, as defined in
+// collectd's types.db file.
+type TypesDB map[string][]DataSource
+
+// ParseTypesDB parses type definitions in the format used by collectd's
+// types.db file. Each line contains a type name followed by its data sources,
+// such as:
+//
+//	if_octets  rx:DERIVE:0:U, tx:DERIVE:0:U
+//
+// Blank lines and lines starting with # are ignored.
+func ParseTypesDB(r io.Reader) (TypesDB, error) {
+	db := TypesDB{}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("types.db line %d: %q has no data sources", line, fields[0])
+		}
+		sources := make([]DataSource, 0, len(fields)-1)
+		for _, field := range fields[1:] {
+			for _, s := range strings.Split(field, ",") {
+				if s == "" {
+					continue
+				}
+				ds, err := parseDataSource(s)
+				if err != nil {
+					return nil, fmt.Errorf("types.db line %d: %v", line, err)
+				}
+				sources = append(sources, ds)
+			}
+		}
+		db[fields[0]] = sources
+	}
+	return db, scanner.Err()
+}
+
+// parseDataSource parses a data source such as "rx:DERIVE:0:U".
+func parseDataSource(s string) (DataSource, error) {
+	fields := strings.Split(s, ":")
+	if len(fields) != 4 {
+		return DataSource{}, fmt.Errorf("%q is not a data source", s)
+	}
+	ds := DataSource{Name: fields[0]}
+	switch strings.ToUpper(fields[1]) {
+	case "COUNTER":
+		ds.Type = TypeCounter
+	case "GAUGE":
+		ds.Type = TypeGauge
+	case "DERIVE":
+		ds.Type = TypeDerive
+	case "ABSOLUTE":
+		ds.Type = TypeAbsolute
+	default:
+		return DataSource{}, fmt.Errorf("%q has unknown type %q", s, fields[1])
+	}
+	var err error
+	ds.Min, err = parseLimit(fields[2])
+	if err != nil {
+		return DataSource{}, fmt.Errorf("%q has invalid minimum %q", s, fields[2])
+	}
+	ds.Max, err = parseLimit(fields[3])
+	if err != nil {
+		return DataSource{}, fmt.Errorf("%q has invalid maximum %q", s, fields[3])
+	}
+	return ds, nil
+}
+
+// parseLimit parses a minimum or maximum, where U means there is no limit.
+func parseLimit(s string) (float64, error) {
+	if s == "U" {
+		return math.NaN(), nil
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+// The types.db file from collectd 5.12.0. It is distributed under collectd's
+// license, the GPLv2, not gocollectd's MIT license; see the notice at the
+// top of the file.
+//
+//go:embed types.db
+var defaultTypes []byte
+
+var defaultTypesDB = sync.OnceValue(func() TypesDB {
+	db, err := ParseTypesDB(bytes.NewReader(defaultTypes))
+	if err != nil {
+		panic(err)
+	}
+	return db
+})
+
+// DefaultTypesDB returns the types defined in collectd 5.12.0's types.db, which
+// is included in this package. Each call returns a new copy, so custom types
+// can be added to it with Overlay or Load.
+func DefaultTypesDB() TypesDB {
+	db := make(TypesDB, len(defaultTypesDB()))
+	for name, sources := range defaultTypesDB() {
+		db[name] = slices.Clone(sources)
+	}
+	return db
+}
+
+// LookupType returns a copy of the data sources of a type in the default
+// TypesDB.
+func LookupType(typ string) ([]DataSource, bool) {
+	sources, ok := defaultTypesDB().Lookup(typ)
+	return slices.Clone(sources), ok
+}
+
+// LoadTypesDB reads the types.db files at each path. Like collectd, types in
+// later files replace types with the same name in earlier ones.
+func LoadTypesDB(paths ...string) (TypesDB, error) {
+	db := TypesDB{}
+	if err := db.Load(paths...); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Load reads the types.db files at each path and adds their types to db,
+// replacing any types with the same name.
+func (db TypesDB) Load(paths ...string) error {
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		types, err := ParseTypesDB(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		db.Overlay(types)
+	}
+	return nil
+}
+
+// Overlay adds the types in other to db, replacing any types with the same
+// name.
+func (db TypesDB) Overlay(other TypesDB) {
+	maps.Copy(db, other)
+}
+
+// Lookup returns the data sources of a type.
+func (db TypesDB) Lookup(typ string) ([]DataSource, bool) {
+	sources, ok := db[typ]
+	return sources, ok
+}
+
+// DataSources returns the data sources of each value in this packet, as
+// defined by its type in db, or in the default TypesDB if db is nil. If the
+// packet's type isn't defined, or its values don't match the type, it returns
+// a *ValidationError. The data sources of the default types are copied, so
+// they can't be changed.
+func (p Packet) DataSources(db TypesDB) ([]DataSource, error) {
+	sources, err := p.dataSources(db)
+	if db == nil {
+		// don't let the default types be changed
+		sources = slices.Clone(sources)
+	}
+	return sources, err
+}
+
+// dataSources is DataSources without copying the default types.
+func (p Packet) dataSources(db TypesDB) ([]DataSource, error) {
+	fail := func(err error, reason string) error {
+		return &ValidationError{p.Identifier(), reason, err}
+	}
+	if db == nil {
+		db = defaultTypesDB()
+	}
+	sources, ok := db[p.Type]
+	if !ok {
+		return nil, fail(ErrorUnknownType, fmt.Sprintf("type %q is not defined", p.Type))
+	}
+	if len(sources) != len(p.DataTypes) {
+		reason := fmt.Sprintf("%d values were sent but type %q has %d data sources", len(p.DataTypes), p.Type, len(sources))
+		return nil, fail(ErrorDataSources, reason)
+	}
+	for i, ds := range sources {
+		if ds.Type != p.DataTypes[i] {
+			reason := fmt.Sprintf("%s value was sent for %s data source %q", dataTypeName(p.DataTypes[i]), dataTypeName(ds.Type), ds.Name)
+			return nil, fail(ErrorDataSources, reason)
+		}
+	}
+	return sources, nil
+}
+
+// DataSourceNames returns the name of each value in this packet, such as
+// "rx" and "tx" for if_octets, as defined by its type in db, or in the default
+// TypesDB if db is nil.
+func (p Packet) DataSourceNames(db TypesDB) ([]string, error) {
+	sources, err := p.dataSources(db)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(sources))
+	for i, ds := range sources {
+		names[i] = ds.Name
+	}
+	return names, nil
+}
+
+// Validate checks that a packet matches its type in db:
+//
+//   - the type must be defined
+//   - the packet must have one value for each of the type's data sources
+//   - each value's type must match its data source's type
+//   - gauge values must be between their data source's minimum and maximum
+//
+// collectd only makes the first two checks; the others go beyond it. The
+// limits of other data sources apply to their rate of change, so they aren't
+// checked. If the packet is invalid Validate returns a *ValidationError. A nil
+// TypesDB validates against the default types.
+func (db TypesDB) Validate(p Packet) error {
+	sources, err := p.dataSources(db)
+	if err != nil {
+		return err
+	}
+	for i, ds := range sources {
+		if ds.Type != TypeGauge {
+			continue
+		}
+		v := math.Float64frombits(binary.BigEndian.Uint64(p.Bytes[i*8:]))
+		if v < ds.Min || v > ds.Max {
+			reason := fmt.Sprintf("value %v of data source %q is outside %v to %v", v, ds.Name, ds.Min, ds.Max)
+			return &ValidationError{p.Identifier(), reason, ErrorOutOfRange}
+		}
+	}
+	return nil
+}
+
+// dataTypeName returns the name types.db uses for a data type.
+func dataTypeName(t uint8) string {
+	switch t {
+	case TypeCounter:
+		return "COUNTER"
+	case TypeGauge:
+		return "GAUGE"
+	case TypeDerive:
+		return "DERIVE"
+	case TypeAbsolute:
+		return "ABSOLUTE"
+	}
+	return "UNKNOWN"
+}
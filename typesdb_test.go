@@ -0,0 +1,282 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testTypesDB = `
+# a comment
+if_octets		rx:DERIVE:0:U, tx:DERIVE:0:U
+load			shortterm:GAUGE:0:5000, midterm:GAUGE:0:5000, longterm:GAUGE:0:5000
+memory			value:GAUGE:0:281474976710656
+counter			value:COUNTER:U:U
+custom			a:absolute:-1.5:1e3,b:gauge:U:U
+`
+
+// sameDataSources compares data sources, treating NaN limits as equal.
+func sameDataSources(a, b []DataSource) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	same := func(x, y float64) bool {
+		return x == y || (math.IsNaN(x) && math.IsNaN(y))
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Type != b[i].Type || !same(a[i].Min, b[i].Min) || !same(a[i].Max, b[i].Max) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseTypesDB(t *testing.T) {
+	db, err := ParseTypesDB(strings.NewReader(testTypesDB))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(db) != 5 {
+		t.Errorf("expected 5 types, got %d", len(db))
+	}
+	nan := math.NaN()
+	tests := []struct {
+		typ      string
+		expected []DataSource
+	}{
+		{"if_octets", []DataSource{{"rx", TypeDerive, 0, nan}, {"tx", TypeDerive, 0, nan}}},
+		{"memory", []DataSource{{"value", TypeGauge, 0, 281474976710656}}},
+		{"counter", []DataSource{{"value", TypeCounter, nan, nan}}},
+		{"custom", []DataSource{{"a", TypeAbsolute, -1.5, 1000}, {"b", TypeGauge, nan, nan}}},
+	}
+	for _, test := range tests {
+		sources, ok := db.Lookup(test.typ)
+		if !ok {
+			t.Errorf("%s: expected type to be found", test.typ)
+		} else if !sameDataSources(sources, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.typ, test.expected, sources)
+		}
+	}
+	if _, ok := db.Lookup("missing"); ok {
+		t.Errorf("expected missing type not to be found")
+	}
+}
+
+func TestParseTypesDBErrors(t *testing.T) {
+	tests := []string{
+		"if_octets",
+		"if_octets rx:DERIVE:0",
+		"if_octets rx:DERIVED:0:U",
+		"if_octets rx:DERIVE:zero:U",
+		"if_octets rx:DERIVE:0:max",
+	}
+	for _, test := range tests {
+		_, err := ParseTypesDB(strings.NewReader("# comment\n" + test))
+		if err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("%q: expected an error on line 2, got %v", test, err)
+		}
+	}
+}
+
+func TestLoadTypesDB(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "types.db")
+	custom := filepath.Join(dir, "custom.db")
+	os.WriteFile(base, []byte(testTypesDB), 0644)
+	os.WriteFile(custom, []byte("memory value:GAUGE:0:U\nextra value:DERIVE:0:U\n"), 0644)
+
+	db, err := LoadTypesDB(base, custom)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(db) != 6 {
+		t.Errorf("expected 6 types, got %d", len(db))
+	}
+	if !math.IsNaN(db["memory"][0].Max) {
+		t.Errorf("expected later files to replace types, got %v", db["memory"])
+	}
+
+	_, err = LoadTypesDB(filepath.Join(dir, "missing"))
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestDefaultTypesDB(t *testing.T) {
+	db := DefaultTypesDB()
+	nan := math.NaN()
+	tests := []struct {
+		typ      string
+		expected []DataSource
+	}{
+		{"if_octets", []DataSource{{"rx", TypeDerive, 0, nan}, {"tx", TypeDerive, 0, nan}}},
+		{"load", []DataSource{{"shortterm", TypeGauge, 0, 5000}, {"midterm", TypeGauge, 0, 5000}, {"longterm", TypeGauge, 0, 5000}}},
+		{"absolute", []DataSource{{"value", TypeAbsolute, 0, nan}}},
+		{"counter", []DataSource{{"value", TypeCounter, nan, nan}}},
+	}
+	for _, test := range tests {
+		sources, ok := LookupType(test.typ)
+		if !ok || !sameDataSources(sources, test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.typ, test.expected, sources)
+		}
+		if !sameDataSources(db[test.typ], test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.typ, test.expected, db[test.typ])
+		}
+	}
+	if _, ok := LookupType("missing"); ok {
+		t.Errorf("expected missing type not to be found")
+	}
+
+	// custom types are added to a copy
+	custom, _ := ParseTypesDB(strings.NewReader("if_octets in:DERIVE:0:U, out:DERIVE:0:U\nextra value:GAUGE:0:U\n"))
+	db.Overlay(custom)
+	if db["if_octets"][0].Name != "in" || db["extra"] == nil {
+		t.Errorf("expected custom types to be overlaid, got %v and %v", db["if_octets"], db["extra"])
+	}
+	if sources, _ := LookupType("if_octets"); sources[0].Name != "rx" {
+		t.Errorf("expected the default types to be unchanged, got %v", sources)
+	}
+	if _, ok := DefaultTypesDB()["extra"]; ok {
+		t.Errorf("expected the default types to be unchanged")
+	}
+
+	// changing the data sources of a copy doesn't change the defaults
+	db["load"][0].Max = 1
+	sources, _ := LookupType("load")
+	sources[1].Max = 1
+	p := Packet{Type: "load", DataTypes: []uint8{TypeGauge, TypeGauge, TypeGauge}}
+	sources, _ = p.DataSources(nil)
+	sources[2].Max = 1
+	for i, ds := range DefaultTypesDB()["load"] {
+		if ds.Max != 5000 {
+			t.Errorf("expected data source %d of the default load type to be unchanged, got %v", i, ds)
+		}
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "custom.db")
+	os.WriteFile(path, []byte("memory value:GAUGE:0:U\n"), 0644)
+	db = DefaultTypesDB()
+	if err := db.Load(path); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !math.IsNaN(db["memory"][0].Max) || db["if_octets"] == nil {
+		t.Errorf("expected memory to be replaced, got %v", db["memory"])
+	}
+}
+
+func TestPacketDataSourcesDefault(t *testing.T) {
+	packets, _ := Parse(h2b(testV5Data...))
+	ifOctets := (*packets)[1]
+
+	names, err := ifOctets.DataSourceNames(nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"rx", "tx"}) {
+		t.Errorf("expected [rx tx], got %v", names)
+	}
+	var db TypesDB
+	if err := db.Validate(ifOctets); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestPacketDataSources(t *testing.T) {
+	db, _ := ParseTypesDB(strings.NewReader(testTypesDB))
+	packets, _ := Parse(h2b(testV5Data...))
+	ifOctets := (*packets)[1]
+
+	names, err := ifOctets.DataSourceNames(db)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"rx", "tx"}) {
+		t.Errorf("expected [rx tx], got %v", names)
+	}
+
+	tests := []struct {
+		p   Packet
+		err error
+	}{
+		{Packet{Type: "missing", DataTypes: []uint8{TypeGauge}}, ErrorUnknownType},
+		{Packet{Type: "if_octets", DataTypes: []uint8{TypeDerive}}, ErrorDataSources},
+		{Packet{Type: "if_octets", DataTypes: []uint8{TypeGauge, TypeGauge}}, ErrorDataSources},
+	}
+	for _, test := range tests {
+		_, err := test.p.DataSources(db)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%v: expected '%v', got '%v'", test.p.DataTypes, test.err, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	db, _ := ParseTypesDB(strings.NewReader(testTypesDB))
+	gauge := func(v float64) []byte {
+		return binary.BigEndian.AppendUint64(nil, math.Float64bits(v))
+	}
+	tests := []struct {
+		p      Packet
+		err    error
+		reason string
+	}{
+		{Packet{"laptop.lan", "memory", "", "memory", "free", 0, 0, []uint8{TypeGauge}, gauge(1024), SecurityNone}, nil, ""},
+		{Packet{"laptop.lan", "memory", "", "memory", "free", 0, 0, []uint8{TypeGauge}, gauge(math.NaN()), SecurityNone}, nil, ""},
+		{Packet{"laptop.lan", "memory", "", "memory", "free", 0, 0, []uint8{TypeGauge}, gauge(-1), SecurityNone}, ErrorOutOfRange, `value -1 of data source "value" is outside 0 to 2.81474976710656e+14`},
+		{Packet{"laptop.lan", "custom", "", "custom", "", 0, 0, []uint8{TypeAbsolute, TypeGauge}, append(make([]byte, 8), gauge(-1e300)...), SecurityNone}, nil, ""},
+		{Packet{"laptop.lan", "foo", "bar", "missing", "baz", 0, 0, []uint8{TypeGauge}, gauge(1), SecurityNone}, ErrorUnknownType, `type "missing" is not defined`},
+		{Packet{"laptop.lan", "interface", "lo0", "if_octets", "", 0, 0, []uint8{TypeDerive}, make([]byte, 8), SecurityNone}, ErrorDataSources, `1 values were sent but type "if_octets" has 2 data sources`},
+		{Packet{"laptop.lan", "interface", "lo0", "if_octets", "", 0, 0, []uint8{TypeDerive, TypeCounter}, make([]byte, 16), SecurityNone}, ErrorDataSources, `COUNTER value was sent for DERIVE data source "tx"`},
+	}
+	for _, test := range tests {
+		err := db.Validate(test.p)
+		if test.err == nil {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", test.p.Identifier(), err)
+			}
+			continue
+		}
+		var ve *ValidationError
+		if !errors.As(err, &ve) {
+			t.Fatalf("%s: expected a *ValidationError, got %v", test.p.Identifier(), err)
+		}
+		if ve.Err != test.err || ve.Reason != test.reason || ve.Identifier != test.p.Identifier() {
+			t.Errorf("%s: expected '%v: %s', got %+v", test.p.Identifier(), test.err, test.reason, ve)
+		}
+	}
+}
+
+func TestParserTypesDB(t *testing.T) {
+	db, _ := ParseTypesDB(strings.NewReader("memory value:GAUGE:0:1000000\n"))
+	var invalid []*ValidationError
+	ps := Parser{TypesDB: db, Invalid: func(err *ValidationError) {
+		invalid = append(invalid, err)
+	}}
+	b, err := Encode(testWriterPackets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets, err := ps.Parse(b)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	// the memory value is too large, and the interface types are unknown
+	if len(*packets) != 0 {
+		t.Errorf("expected no packets, got %v", *packets)
+	}
+	if len(invalid) != 3 {
+		t.Fatalf("expected 3 invalid packets, got %d", len(invalid))
+	}
+	if invalid[0].Identifier != "laptop.lan/memory/memory-wired" || invalid[0].Err != ErrorOutOfRange {
+		t.Errorf("unexpected error %v", invalid[0])
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultPacketSize is the default maximum size of a datagram. It is the same
+// as the default for collectd's MaxPacketSize option.
+const DefaultPacketSize = 1452
+
+// The error returned if a packet is too large to fit in a datagram
+var ErrorPacketSize = errors.New("Packet is larger than the maximum datagram size")
+
+// The error returned if a Writer signs or encrypts data without a username
+var ErrorNoUsername = errors.New("Signing or encrypting collectd data requires a username")
+
+// A Writer buffers packets and writes them to an io.Writer as collectd
+// datagrams, with each call to the io.Writer's Write method containing one
+// datagram.
+//
+// Like collectd, the parts that identify a packet and its time are only
+// written when they change from the previous packet in the same datagram.
+type Writer struct {
+	// MaxSize is the maximum size of each datagram, including any signature
+	// or encryption.
+	MaxSize int
+
+	// SecurityLevel is used to sign or encrypt each datagram with Username
+	// and Password, in the same way as collectd's SecurityLevel option.
+	SecurityLevel SecurityLevel
+	Username      string
+	Password      string
+
+	// FlushInterval is the longest time a packet is buffered before it is
+	// written. If it is zero packets are only written when the buffer is
+	// full or Flush is called.
+	// Errors from these timed writes are returned by the next call to Flush.
+	FlushInterval time.Duration
+
+	w       io.Writer
+	mu      sync.Mutex
+	buf     []byte
+	scratch []byte
+	last    Packet
+	timer   *time.Timer
+	err     error
+}
+
+// NewWriter returns a new Writer that writes datagrams of up to
+// DefaultPacketSize bytes to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{MaxSize: DefaultPacketSize, w: w}
+}
+
+// WritePacket adds a packet to the buffer. If the packet does not fit in the
+// current datagram, the buffered packets are written first.
+func (w *Writer) WritePacket(p Packet) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	maxSize, err := w.maxDataSize()
+	if err != nil {
+		return err
+	}
+
+	var last *Packet
+	if len(w.buf) > 0 {
+		last = &w.last
+	}
+	b, err := appendPacket(w.scratch[:0], p, last)
+	if err != nil {
+		return err
+	}
+
+	if len(w.buf) > 0 && len(w.buf)+len(b) > maxSize {
+		err = w.flush()
+		if err != nil {
+			return err
+		}
+		// the new datagram needs every part of this packet
+		b, err = appendPacket(b[:0], p, nil)
+		if err != nil {
+			return err
+		}
+	}
+	w.scratch = b
+	if len(b) > maxSize {
+		return ErrorPacketSize
+	}
+
+	if len(w.buf) == 0 && w.FlushInterval > 0 {
+		w.timer = time.AfterFunc(w.FlushInterval, w.timedFlush)
+	}
+	w.buf = append(w.buf, b...)
+	w.last = p
+	return nil
+}
+
+// WriteNotification writes a notification in its own datagram straight away,
+// as collectd does.
+func (w *Writer) WriteNotification(n Notification) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	maxSize, err := w.maxDataSize()
+	if err != nil {
+		return err
+	}
+	b, err := AppendNotification(nil, n)
+	if err != nil {
+		return err
+	}
+	if len(b) > maxSize {
+		return ErrorPacketSize
+	}
+	return w.write(b)
+}
+
+// Flush writes any buffered packets. If a timed flush failed since the last
+// call to Flush, its error is returned.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.flush()
+	if w.err != nil {
+		err = w.err
+		w.err = nil
+	}
+	return err
+}
+
+// Buffered returns the number of bytes waiting to be written.
+func (w *Writer) Buffered() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.buf)
+}
+
+// flush writes the buffer as a datagram. The buffer is emptied even if the
+// write fails, as there is no point retrying a failed datagram.
+func (w *Writer) flush() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if len(w.buf) == 0 {
+		return nil
+	}
+	err := w.write(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+// write signs or encrypts b if needed, then writes it as a datagram.
+func (w *Writer) write(b []byte) error {
+	var err error
+	switch w.SecurityLevel {
+	case SecuritySign:
+		b = sign(w.Username, w.Password, b)
+	case SecurityEncrypt:
+		b, err = encrypt(w.Username, w.Password, b)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = w.w.Write(b)
+	return err
+}
+
+// maxDataSize returns the space available in each datagram once any
+// signature or encryption has been added.
+func (w *Writer) maxDataSize() (int, error) {
+	switch w.SecurityLevel {
+	case SecuritySign:
+		if w.Username == "" {
+			return 0, ErrorNoUsername
+		}
+		return w.MaxSize - signatureOverhead(w.Username), nil
+	case SecurityEncrypt:
+		if w.Username == "" {
+			return 0, ErrorNoUsername
+		}
+		return w.MaxSize - encryptionOverhead(w.Username), nil
+	}
+	return w.MaxSize, nil
+}
+
+// timedFlush is called by the timer started when a packet is added to an
+// empty buffer.
+func (w *Writer) timedFlush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.flush()
+	if err != nil {
+		w.err = err
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2013 Paul Hammond.
+// This software is licensed under the MIT license, see LICENSE.txt for details.
+
+package gocollectd
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// datagramRecorder is an io.Writer that records each write as a datagram.
+type datagramRecorder struct {
+	mu        sync.Mutex
+	datagrams [][]byte
+	written   chan bool
+	fail      error // returned instead of recording the next write
+}
+
+func (r *datagramRecorder) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	if err := r.fail; err != nil {
+		r.fail = nil
+		r.mu.Unlock()
+		if r.written != nil {
+			r.written <- true
+		}
+		return 0, err
+	}
+	r.datagrams = append(r.datagrams, append([]byte(nil), b...))
+	r.mu.Unlock()
+	if r.written != nil {
+		r.written <- true
+	}
+	return len(b), nil
+}
+
+var testWriterPackets = []Packet{
+	{"laptop.lan", "memory", "", "memory", "wired", 1463827927039889790, 10737418240, []uint8{TypeGauge}, h2b("41 cf 43 00 00 00 00 00"), SecurityNone},
+	{"laptop.lan", "memory", "", "memory", "free", 1463827927039889790, 10737418240, []uint8{TypeGauge}, h2b("41 cf 43 00 00 00 00 00"), SecurityNone},
+	{"laptop.lan", "interface", "lo0", "if_octets", "", 1463827927039906970, 10737418240, []uint8{TypeDerive, TypeDerive}, h2b("00 00 00 00 00 88 07 8b 00 00 00 00 00 88 07 8c"), SecurityNone},
+}
+
+func TestWriter(t *testing.T) {
+	r := &datagramRecorder{}
+	w := NewWriter(r)
+	for _, p := range testWriterPackets {
+		err := w.WritePacket(p)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	if len(r.datagrams) != 0 {
+		t.Errorf("expected nothing to be written before Flush, got %d datagrams", len(r.datagrams))
+	}
+	err := w.Flush()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(r.datagrams) != 1 {
+		t.Fatalf("expected 1 datagram, got %d", len(r.datagrams))
+	}
+
+	expected := h2b(
+		"00 00 00 0f 6c 61 70 74 6f 70 2e 6c 61 6e 00",                            // hostname: "laptop.lan"
+		"00 08 00 0c 14 50 8f be 73 82 51 7e",                                     // time, hi res
+		"00 09 00 0c 00 00 00 02 80 00 00 00",                                     // interval, hi res
+		"00 02 00 0b 6d 65 6d 6f 72 79 00",                                        // plugin: memory
+		"00 03 00 05 00",                                                          // plugin instance: nil
+		"00 04 00 0b 6d 65 6d 6f 72 79 00",                                        // type: memory
+		"00 05 00 0a 77 69 72 65 64 00",                                           // type instance: wired
+		"00 06 00 0f 00 01 01 00 00 00 00 00 43 cf 41",                            // value
+		"00 05 00 09 66 72 65 65 00",                                              // type instance: free
+		"00 06 00 0f 00 01 01 00 00 00 00 00 43 cf 41",                            // value
+		"00 08 00 0c 14 50 8f be 73 82 94 9a",                                     // time, hi res
+		"00 02 00 0e 69 6e 74 65 72 66 61 63 65 00",                               // plugin: interface
+		"00 03 00 08 6c 6f 30 00",                                                 // instance: lo0
+		"00 04 00 0e 69 66 5f 6f 63 74 65 74 73 00",                               // type: if_octets
+		"00 05 00 05 00",                                                          // type instance: nil
+		"00 06 00 18 00 02 02 02 00 00 00 00 00 88 07 8b 00 00 00 00 00 88 07 8c", // values
+	)
+	if !reflect.DeepEqual(r.datagrams[0], expected) {
+		t.Errorf("expected\n%v\ngot\n%v\n", expected, r.datagrams[0])
+	}
+	if w.Buffered() != 0 {
+		t.Errorf("expected empty buffer, got %d bytes", w.Buffered())
+	}
+}
+
+func TestWriterMaxSize(t *testing.T) {
+	r := &datagramRecorder{}
+	w := NewWriter(r)
+	w.MaxSize = 120
+	for _, p := range testWriterPackets {
+		err := w.WritePacket(p)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+	w.Flush()
+
+	if len(r.datagrams) != 2 {
+		t.Fatalf("expected 2 datagrams, got %d", len(r.datagrams))
+	}
+	var result []Packet
+	for _, d := range r.datagrams {
+		if len(d) > w.MaxSize {
+			t.Errorf("expected datagram of at most %d bytes, got %d", w.MaxSize, len(d))
+		}
+		// each datagram has to make sense on its own
+		packets, err := Parse(d)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		result = append(result, *packets...)
+	}
+	if !reflect.DeepEqual(result, testWriterPackets) {
+		t.Errorf("expected\n%v\ngot\n%v\n", testWriterPackets, result)
+	}
+
+	w.MaxSize = 50
+	err := w.WritePacket(testWriterPackets[0])
+	if err != ErrorPacketSize {
+		t.Errorf("expected '%v', got '%v'", ErrorPacketSize, err)
+	}
+}
+
+func TestWriterFlushInterval(t *testing.T) {
+	r := &datagramRecorder{written: make(chan bool, 1)}
+	w := NewWriter(r)
+	w.FlushInterval = 10 * time.Millisecond
+	err := w.WritePacket(testWriterPackets[0])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	select {
+	case <-r.written:
+	case <-time.After(time.Second):
+		t.Fatalf("expected packet to be written")
+	}
+	if w.Buffered() != 0 {
+		t.Errorf("expected empty buffer, got %d bytes", w.Buffered())
+	}
+}
+
+func TestWriterFlushIntervalError(t *testing.T) {
+	failed := errors.New("connection refused")
+	r := &datagramRecorder{written: make(chan bool, 1), fail: failed}
+	w := NewWriter(r)
+	w.FlushInterval = 10 * time.Millisecond
+	w.WritePacket(testWriterPackets[0])
+	select {
+	case <-r.written:
+	case <-time.After(time.Second):
+		t.Fatalf("expected timed flush")
+	}
+
+	// the failed flush doesn't stop the next packet being written
+	err := w.WritePacket(testWriterPackets[1])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	select {
+	case <-r.written:
+	case <-time.After(time.Second):
+		t.Fatalf("expected packet to be written")
+	}
+	packets, err := Parse(r.datagrams[0])
+	if err != nil || len(*packets) != 1 || (*packets)[0].TypeInstance != "free" {
+		t.Errorf("expected the second packet to be written, got %v, %v", packets, err)
+	}
+
+	// the error is returned by Flush instead
+	if err := w.Flush(); err != failed {
+		t.Errorf("expected '%v', got '%v'", failed, err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
+
+func TestWriterNotification(t *testing.T) {
+	r := &datagramRecorder{}
+	w := NewWriter(r)
+	w.WritePacket(testWriterPackets[0])
+	n := Notification{"laptop.lan", "ping", "", "", "", 1463827927039889790, SeverityWarning, "Host unreachable", SecurityNone}
+	err := w.WriteNotification(n)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(r.datagrams) != 1 {
+		t.Fatalf("expected 1 datagram, got %d", len(r.datagrams))
+	}
+	_, notifications, err := ParseAll(r.datagrams[0])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if !reflect.DeepEqual(*notifications, []Notification{n}) {
+		t.Errorf("expected\n%v\ngot\n%v\n", []Notification{n}, *notifications)
+	}
+}